@@ -11,11 +11,31 @@ function main() {
     exit 1
   fi
 
-  local version expected_sha dir
+  local version expected_sha dir arch
   version="1.25.6"
-  expected_sha="0ed64e3b9cb9b1c2ec57880dae2427b0ee2676f2ae2fb53c2e1bb838c500f9fb"
   dir="/tmp/go${version}"
 
+  case "$(uname -m)" in
+    x86_64 | amd64)
+      arch="x64"
+      expected_sha="0ed64e3b9cb9b1c2ec57880dae2427b0ee2676f2ae2fb53c2e1bb838c500f9fb"
+      ;;
+    aarch64 | arm64)
+      arch="arm64"
+      # Pin the sha256 of go_${version}_linux_arm64_cflinuxfs4_<sha>.tgz
+      expected_sha=""
+      ;;
+    *)
+      echo "       **ERROR** Unsupported architecture $(uname -m)"
+      exit 1
+      ;;
+  esac
+
+  if [[ -z "${expected_sha}" ]]; then
+    echo "       **ERROR** No go ${version} dependency for ${arch}"
+    exit 1
+  fi
+
   mkdir -p "${dir}"
 
   if [[ ! -f "${dir}/bin/go" ]]; then
@@ -25,9 +45,9 @@ function main() {
     if [[ "${CF_STACK}" == "cflinuxfs5" ]]; then
       stack_for_download="cflinuxfs4"
     fi
-    url="https://buildpacks.cloudfoundry.org/dependencies/go/go_${version}_linux_x64_${stack_for_download}_${expected_sha:0:8}.tgz"
+    url="https://buildpacks.cloudfoundry.org/dependencies/go/go_${version}_linux_${arch}_${stack_for_download}_${expected_sha:0:8}.tgz"
 
-    echo "-----> Download go ${version}"
+    echo "-----> Download go ${version} (${arch})"
     curl "${url}" \
       --silent \
       --location \
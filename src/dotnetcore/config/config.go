@@ -1,5 +1,67 @@
 package config
 
 type Config struct {
-	DotnetSdkVersion string
+	DotnetSdkVersion   string
+	TargetFramework    string
+	FrameworkDependent bool
+	NativeAot          bool
+	Publish            Publish
+	Test               Test
+	Processes          map[string]string
+	Migrations         Migrations
+	Start              Start
+}
+
+// Publish holds the dotnet publish settings from the publish section of
+// buildpack.yml
+type Publish struct {
+	Configuration     string
+	RuntimeIdentifier string
+	Properties        map[string]string
+	Profile           string
+	Verbosity         string
+	LockedMode        *bool
+	ReadyToRun        bool
+	Trimmed           bool
+	SingleFile        bool
+}
+
+// Test holds the settings of the dotnet test gate from the test section of
+// buildpack.yml
+type Test struct {
+	Enabled bool
+	Filter  string
+}
+
+// Migrations holds the settings of the Entity Framework migrations bundle from
+// the migrations section of buildpack.yml
+type Migrations struct {
+	Enabled bool
+	Process string
+	Project string
+	Context string
+}
+
+// Start holds the settings of the app's start command from the start section
+// of buildpack.yml
+type Start struct {
+	Assembly   string
+	Args       string
+	WorkingDir string
+}
+
+// OptionProperties returns the MSBuild properties that turn on the publish
+// options set in buildpack.yml
+func (p Publish) OptionProperties() map[string]string {
+	properties := map[string]string{}
+	if p.ReadyToRun {
+		properties["PublishReadyToRun"] = "true"
+	}
+	if p.Trimmed {
+		properties["PublishTrimmed"] = "true"
+	}
+	if p.SingleFile {
+		properties["PublishSingleFile"] = "true"
+	}
+	return properties
 }
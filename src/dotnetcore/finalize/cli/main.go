@@ -9,6 +9,7 @@ import (
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/finalize"
 	_ "github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/hooks"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -43,6 +44,7 @@ func main() {
 		logger.Error("Unable to apply override.yml files: %s", err)
 		os.Exit(17)
 	}
+	platform.FilterManifest(manifest, platform.Arch())
 
 	if err := stager.SetStagingEnvironment(); err != nil {
 		logger.Error("Unable to setup environment variables: %s", err.Error())
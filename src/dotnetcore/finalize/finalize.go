@@ -1,25 +1,23 @@
 package finalize
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/kr/text"
 )
 
-var stackToRuntimeRID = map[string]string{
-	"cflinuxfs3": "linux-x64",
-	"cflinuxfs4": "linux-x64",
-	"cflinuxfs5": "linux-x64",
-}
-
 type Project interface {
 	IsPublished() (bool, error)
 	StartCommand() (string, error)
@@ -31,6 +29,7 @@ type Project interface {
 
 type Stager interface {
 	BuildDir() string
+	CacheDir() string
 	DepsIdx() string
 	DepDir() string
 	WriteProfileD(string, string) error
@@ -46,10 +45,14 @@ type Finalizer struct {
 	Command Command
 	Config  *config.Config
 	Project *project.Project
+
+	nugetKey string
 }
 
 func Run(f *Finalizer) error {
 	f.Log.BeginStep("Finalizing Dotnet Core")
+	f.Project.SetEntryAssembly(f.Config.Start.Assembly)
+
 	isFrameworkDependent, err := f.Project.IsFDD()
 	if err != nil {
 		return err
@@ -60,16 +63,60 @@ func Run(f *Finalizer) error {
 		return err
 	}
 
-	stack := os.Getenv("CF_STACK")
-	stackRID := stackToRuntimeRID[stack]
-	if stackRID == "" {
-		f.Log.Error("Unsupported stack: %s", stack)
+	stackRID, err := platform.RuntimeIdentifier(os.Getenv("CF_STACK"), platform.Arch())
+	if err != nil {
+		f.Log.Error("Unable to determine the runtime identifier: %s", err.Error())
 		return err
 	}
 
 	if isSourceBased {
-		if err := f.Project.SourceInstallDotnetRuntime(); err != nil {
-			f.Log.Error("Unable to install dotnet-runtime: %s", err.Error())
+		f.Project.SetGlobalProperty("Configuration", f.publicConfig())
+		for name, value := range f.Config.Publish.Properties {
+			f.Project.SetGlobalProperty(name, value)
+		}
+		for name, value := range f.Config.Publish.OptionProperties() {
+			f.Project.SetGlobalProperty(name, value)
+		}
+		if f.Config.TargetFramework != "" {
+			f.Project.SetGlobalProperty("TargetFramework", f.Config.TargetFramework)
+		}
+
+		// Native AOT apps are compiled to native code and never run on the
+		// shared runtime
+		if !f.Config.NativeAot {
+			if err := f.Project.SourceInstallDotnetRuntime(f.Config.TargetFramework); err != nil {
+				f.Log.Error("Unable to install dotnet-runtime: %s", err.Error())
+				return err
+			}
+		}
+
+		if err := f.CheckVendoredPackages(stackRID); err != nil {
+			f.Log.Error("Unable to restore from vendored NuGet packages: %s", err.Error())
+			return err
+		}
+
+		if err := f.ConfigureNuGetFeeds(); err != nil {
+			f.Log.Error("Unable to configure NuGet feeds: %s", err.Error())
+			return err
+		}
+
+		if err := f.RestoreNuGetPackages(); err != nil {
+			f.Log.Error("Unable to restore NuGet packages from cache: %s", err.Error())
+			return err
+		}
+
+		if err := f.RestoreLocalTools(); err != nil {
+			f.Log.Error("Unable to restore .NET tools: %s", err.Error())
+			return err
+		}
+
+		if err := f.RunPublishHook(PrePublishHook); err != nil {
+			f.Log.Error("Unable to run the pre-publish hook: %s", err.Error())
+			return err
+		}
+
+		if err := f.RunTests(); err != nil {
+			f.Log.Error("Unable to run tests: %s", err.Error())
 			return err
 		}
 
@@ -77,6 +124,40 @@ func Run(f *Finalizer) error {
 			f.Log.Error("Unable to run dotnet publish: %s", err.Error())
 			return err
 		}
+
+		if err := f.RunPublishHook(PostPublishHook); err != nil {
+			f.Log.Error("Unable to run the post-publish hook: %s", err.Error())
+			return err
+		}
+
+		if err := f.BuildMigrationsBundle(stackRID); err != nil {
+			f.Log.Error("Unable to build the migrations bundle: %s", err.Error())
+			return err
+		}
+
+		if err := f.SaveNuGetPackages(); err != nil {
+			f.Log.Error("Unable to save NuGet packages to cache: %s", err.Error())
+			return err
+		}
+
+		if f.Config.NativeAot {
+			if err := f.checkNativeExecutable(); err != nil {
+				f.Log.Error("Unable to publish with Native AOT: %s", err.Error())
+				return err
+			}
+		}
+
+		if f.Config.FrameworkDependent {
+			if err := f.installPublishedFrameworks(); err != nil {
+				f.Log.Error("Unable to install frameworks: %s", err.Error())
+				return err
+			}
+		}
+
+		if err := f.RewriteProcfile(); err != nil {
+			f.Log.Error("Unable to rewrite the Procfile: %s", err.Error())
+			return err
+		}
 	}
 
 	if isFrameworkDependent {
@@ -86,6 +167,11 @@ func Run(f *Finalizer) error {
 		}
 	}
 
+	if err := f.CheckStartCommand(); err != nil {
+		f.Log.Error("Invalid start settings in buildpack.yml: %s", err.Error())
+		return err
+	}
+
 	if err := f.CleanStagingArea(); err != nil {
 		f.Log.Error("Unable to run CleanStagingArea: %s", err.Error())
 		return err
@@ -105,10 +191,34 @@ func Run(f *Finalizer) error {
 	return libbuildpack.NewYAML().Write(releasePath, data)
 }
 
+// installPublishedFrameworks installs the shared frameworks the main project
+// and the projects of other processes were published framework-dependent for
+func (f *Finalizer) installPublishedFrameworks() error {
+	if err := f.Project.InstallPublishedFrameworks(); err != nil {
+		return err
+	}
+
+	processes, err := f.processes()
+	if err != nil {
+		return err
+	}
+
+	for _, proc := range processes {
+		if err := f.Project.InstallProcessFrameworks(proc.name); err != nil {
+			return fmt.Errorf("process %s: %w", proc.name, err)
+		}
+	}
+	return nil
+}
+
 func (f *Finalizer) CleanStagingArea() error {
 	f.Log.BeginStep("Cleaning staging area")
 
-	dirsToRemove := []string{"nuget", ".nuget", ".local", ".cache", ".config", ".npm"}
+	if err := f.removeNuGetConfig(); err != nil {
+		return err
+	}
+
+	dirsToRemove := []string{"nuget", ".nuget", ".local", ".cache", ".config", ".npm", ".dotnet", "dotnet-tools"}
 
 	isFDD, err := f.Project.IsFDD()
 	if err != nil {
@@ -120,7 +230,12 @@ func (f *Finalizer) CleanStagingArea() error {
 		return err
 	}
 
-	if !(isFDD || strings.HasSuffix(startCmd, ".dll")) {
+	if f.Config.FrameworkDependent {
+		f.Log.Info("Removing unused files from dotnet-sdk")
+		if err := f.Project.RemoveUnusedRuntimeFiles(); err != nil {
+			return err
+		}
+	} else if f.Config.NativeAot || !(isFDD || strings.HasSuffix(startCmd, ".dll")) {
 		dirsToRemove = append(dirsToRemove, "dotnet-sdk")
 	}
 
@@ -174,12 +289,35 @@ func (f *Finalizer) removeSymlinksTo(dir string) error {
 }
 
 func (f *Finalizer) WriteProfileD() error {
-	scriptContents := fmt.Sprintf(`
+	scriptContents := `
 export ASPNETCORE_URLS="${ASPNETCORE_URLS:-http://0.0.0.0:${PORT}}"
-export DOTNET_ROOT=%s
-`, filepath.Join("/home", "vcap", "deps", f.Stager.DepsIdx(), "dotnet-sdk"))
+`
+	if !f.Config.NativeAot {
+		scriptContents += fmt.Sprintf("export DOTNET_ROOT=%s\n", filepath.Join("/home", "vcap", "deps", f.Stager.DepsIdx(), "dotnet-sdk"))
+	}
+
+	if err := f.Stager.WriteProfileD("startup.sh", scriptContents); err != nil {
+		return err
+	}
 
-	return f.Stager.WriteProfileD("startup.sh", scriptContents)
+	return f.writeMemoryProfileD()
+}
+
+// checkNativeExecutable makes sure that publishing with Native AOT produced
+// the native executable the app is started with, rather than an assembly that
+// needs the runtime the droplet will not have
+func (f *Finalizer) checkNativeExecutable() error {
+	startCmd, err := f.Project.StartCommand()
+	if err != nil {
+		return err
+	}
+
+	if startCmd == "" || strings.HasSuffix(startCmd, ".dll") {
+		return errors.New("dotnet publish did not produce a native executable, make sure PublishAot is set for the runtime the app is published for")
+	}
+
+	f.Log.Info("Starting the app with the native executable %s", filepath.Base(startCmd))
+	return nil
 }
 
 func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error) {
@@ -187,14 +325,34 @@ func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error)
 	if err != nil {
 		return nil, err
 	}
-	directory := filepath.Dir(startCmd)
-	startCmd = "./" + filepath.Base(startCmd)
-	if strings.HasSuffix(startCmd, ".dll") {
-		startCmd = "dotnet " + startCmd
+	processTypes := map[string]string{"web": f.webCommand(startCmd)}
+
+	isSourceBased, err := f.Project.IsSourceBased()
+	if err != nil || !isSourceBased {
+		return map[string]map[string]string{"default_process_types": processTypes}, err
+	}
+
+	processes, err := f.processes()
+	if err != nil {
+		return nil, err
+	}
+
+	commands, err := f.processCommands(processes)
+	if err != nil {
+		return nil, err
+	}
+	for name, command := range commands {
+		processTypes[name] = command
+	}
+
+	if f.Config.Migrations.Enabled {
+		if _, ok := processTypes[f.Config.Migrations.Process]; ok {
+			return nil, fmt.Errorf("process %s of the migrations bundle is already defined", f.Config.Migrations.Process)
+		}
+		processTypes[f.Config.Migrations.Process] = f.migrationsCommand()
 	}
-	return map[string]map[string]string{
-		"default_process_types": {"web": fmt.Sprintf("cd %s && exec %s", directory, startCmd)},
-	}, nil
+
+	return map[string]map[string]string{"default_process_types": processTypes}, nil
 }
 
 func (f *Finalizer) DotnetPublish(stackRID string) error {
@@ -211,35 +369,182 @@ func (f *Finalizer) DotnetPublish(stackRID string) error {
 		return err
 	}
 
-	env := f.shellEnvironment()
-	env = append(env, "PATH="+filepath.Join(filepath.Dir(mainProject), "node_modules", ".bin")+":"+os.Getenv("PATH"))
+	lockFiles, err := f.lockedModeLockFiles()
+	if err != nil {
+		return err
+	}
+
+	vendoredPackages, err := f.vendoredPackagesDir()
+	if err != nil {
+		return err
+	}
 
 	publishPath := filepath.Join(f.Stager.DepDir(), "dotnet_publish")
+	if err := f.publishProject(mainProject, publishPath, f.Config.TargetFramework, stackRID, len(lockFiles) > 0, vendoredPackages); err != nil {
+		return err
+	}
+
+	processes, err := f.processes()
+	if err != nil {
+		return err
+	}
+
+	// The lock files found for the main project say nothing about the
+	// projects of other processes, so those only restore in locked mode when
+	// buildpack.yml asks for it
+	lockedMode := f.Config.Publish.LockedMode != nil && *f.Config.Publish.LockedMode
+	for _, proc := range processes {
+		targetFramework, err := f.Project.ProcessTargetFramework(proc.project, f.Config.TargetFramework)
+		if err != nil {
+			return err
+		}
+
+		if err := f.publishProject(proc.project, f.Project.ProcessPublishDir(proc.name), targetFramework, stackRID, lockedMode, vendoredPackages); err != nil {
+			return fmt.Errorf("publishing process %s: %w", proc.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *Finalizer) publishProject(project, publishPath, targetFramework, stackRID string, lockedMode bool, vendoredPackages string) error {
+	env := f.shellEnvironment()
+	env = append(env, "PATH="+filepath.Join(filepath.Dir(project), "node_modules", ".bin")+":"+f.localToolsBinDir()+":"+os.Getenv("PATH"))
+
 	if err := os.MkdirAll(publishPath, 0755); err != nil {
 		return err
 	}
-	args := []string{"publish", mainProject, "-o", publishPath, "-c", f.publicConfig(), "--self-contained"}
-	args = append(args, "-r", stackRID)
+
+	args := f.publishArgs(project, publishPath, targetFramework, stackRID, lockedMode, vendoredPackages)
+	output := &bytes.Buffer{}
 	cmd := exec.Command("dotnet", args...)
 	cmd.Dir = f.Stager.BuildDir()
 	cmd.Env = env
-	cmd.Stdout = indentWriter(os.Stdout)
+	cmd.Stdout = io.MultiWriter(indentWriter(os.Stdout), output)
 	cmd.Stderr = indentWriter(os.Stderr)
 
 	f.Log.Debug("Running command: %v", cmd)
 	if err := f.Command.Run(cmd); err != nil {
+		if lockFileErr := f.lockFileError(output.String()); lockFileErr != nil {
+			return lockFileErr
+		}
 		return err
 	}
 
 	return nil
 }
 
-func (f *Finalizer) publicConfig() string {
-	if os.Getenv("PUBLISH_RELEASE_CONFIG") == "true" {
-		return "Release"
+// publishArgs builds the dotnet publish arguments from the publish settings in
+// buildpack.yml and logs a summary of them
+func (f *Finalizer) publishArgs(mainProject, publishPath, targetFramework, stackRID string, lockedMode bool, vendoredPackages string) []string {
+	publish := f.Config.Publish
+	runtimeIdentifier := f.runtimeIdentifier(stackRID)
+
+	selfContained := "true"
+	if f.Config.FrameworkDependent {
+		selfContained = "false"
+	}
+
+	f.Log.Info("Publishing %s", filepath.Base(mainProject))
+	f.Log.Info("  configuration: %s", f.publicConfig())
+	f.Log.Info("  runtime: %s (self-contained: %s)", runtimeIdentifier, selfContained)
+
+	args := []string{"publish", mainProject, "-o", publishPath, "-c", f.publicConfig()}
+	if f.Config.FrameworkDependent {
+		args = append(args, "--self-contained", "false")
+	} else {
+		args = append(args, "--self-contained")
+	}
+	args = append(args, "-r", runtimeIdentifier)
+
+	if targetFramework != "" {
+		f.Log.Info("  framework: %s", targetFramework)
+		args = append(args, "-f", targetFramework)
+	}
+
+	if lockedMode {
+		f.Log.Info("  locked mode: true")
+	}
+	if vendoredPackages != "" {
+		f.Log.Info("  package source: %s", vendoredPackagesDirName)
+	}
+	args = append(args, restoreArgs(lockedMode, vendoredPackages)...)
+
+	options := publish.OptionProperties()
+	optionNames := make([]string, 0, len(options))
+	for name := range options {
+		optionNames = append(optionNames, name)
+	}
+	sort.Strings(optionNames)
+	for _, name := range optionNames {
+		f.Log.Info("  %s: %s", name, options[name])
+		args = append(args, fmt.Sprintf("-p:%s=%s", name, options[name]))
+	}
+
+	if publish.Profile != "" {
+		f.Log.Info("  profile: %s", publish.Profile)
+		args = append(args, "-p:PublishProfile="+publish.Profile)
 	}
 
-	return "Debug"
+	names := make([]string, 0, len(publish.Properties))
+	for name := range publish.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f.Log.Info("  property: %s=%s", name, publish.Properties[name])
+		// MSBuild splits property values on semicolons unless they are escaped
+		args = append(args, fmt.Sprintf("-p:%s=%s", name, strings.ReplaceAll(publish.Properties[name], ";", "%3B")))
+	}
+
+	if publish.Verbosity != "" {
+		f.Log.Info("  verbosity: %s", publish.Verbosity)
+		args = append(args, "-v", publish.Verbosity)
+	}
+
+	return args
+}
+
+// restoreProperties returns the MSBuild properties that make restore check
+// the NuGet lock files and restore from the vendored packages only
+func restoreProperties(lockedMode bool, vendoredPackages string) []string {
+	var properties []string
+	if lockedMode {
+		properties = append(properties, "RestoreLockedMode=true")
+	}
+
+	if vendoredPackages != "" {
+		// RestoreSources replaces every package source from NuGet.Config files,
+		// and the vulnerability audit would otherwise try to reach nuget.org
+		properties = append(properties, "RestoreSources="+vendoredPackages, "NuGetAudit=false")
+	}
+	return properties
+}
+
+// restoreArgs returns the restoreProperties as arguments of dotnet publish and
+// dotnet test
+func restoreArgs(lockedMode bool, vendoredPackages string) []string {
+	var args []string
+	for _, property := range restoreProperties(lockedMode, vendoredPackages) {
+		args = append(args, "-p:"+property)
+	}
+	return args
+}
+
+// runtimeIdentifier returns the runtime identifier the app is published for,
+// which is the stack's unless buildpack.yml sets one
+func (f *Finalizer) runtimeIdentifier(stackRID string) string {
+	if f.Config.Publish.RuntimeIdentifier != "" {
+		return f.Config.Publish.RuntimeIdentifier
+	}
+	return stackRID
+}
+
+func (f *Finalizer) publicConfig() string {
+	if f.Config.Publish.Configuration != "" {
+		return f.Config.Publish.Configuration
+	}
+	return project.PublishConfiguration()
 }
 
 func (f *Finalizer) shellEnvironment() []string {
@@ -248,6 +553,7 @@ func (f *Finalizer) shellEnvironment() []string {
 		"DOTNET_SKIP_FIRST_TIME_EXPERIENCE=true",
 		"DefaultItemExcludes=.cloudfoundry/**/*.*",
 		"HOME=" + f.Stager.DepDir(),
+		"PATH=" + f.localToolsBinDir() + ":" + os.Getenv("PATH"),
 	} {
 		env = append(env, v)
 	}
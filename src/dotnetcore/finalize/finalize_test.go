@@ -2,8 +2,12 @@ package finalize_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/finalize"
@@ -21,6 +25,7 @@ var _ = Describe("Finalize", func() {
 	var (
 		err         error
 		buildDir    string
+		cacheDir    string
 		depsDir     string
 		depsIdx     string
 		finalizer   *finalize.Finalizer
@@ -36,6 +41,10 @@ var _ = Describe("Finalize", func() {
 		Expect(err).To(BeNil())
 		DeferCleanup(os.RemoveAll, buildDir)
 
+		cacheDir, err = os.MkdirTemp("", "dotnet-core-buildpack.cache.")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, cacheDir)
+
 		depsDir, err = os.MkdirTemp("", "dotnet-core-buildpack.deps.")
 		Expect(err).To(BeNil())
 		DeferCleanup(os.RemoveAll, depsDir)
@@ -50,7 +59,7 @@ var _ = Describe("Finalize", func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockCommand = NewMockCommand(mockCtrl)
 
-		args := []string{buildDir, "", depsDir, depsIdx}
+		args := []string{buildDir, cacheDir, depsDir, depsIdx}
 		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
 		project := project.New(stager.BuildDir(), filepath.Join(depsDir, depsIdx), depsIdx, &libbuildpack.Manifest{}, libbuildpack.NewInstaller(&libbuildpack.Manifest{}), logger)
 		cfg := &config.Config{}
@@ -80,6 +89,102 @@ var _ = Describe("Finalize", func() {
 				mockCommand.EXPECT().Run(gomock.Any())
 				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
 			})
+
+			It("Passes the selected target framework to dotnet publish", func() {
+				finalizer.Config.TargetFramework = "net8.0"
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).To(ContainElements("-f", "net8.0"))
+				})
+				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			})
+
+			It("Publishes a self-contained app by default", func() {
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).To(ContainElement("--self-contained"))
+					Expect(cmd.Args).NotTo(ContainElement("false"))
+				})
+				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			})
+
+			It("Uses the publish settings from buildpack.yml", func() {
+				finalizer.Config.Publish = config.Publish{
+					Configuration:     "Staging",
+					RuntimeIdentifier: "linux-arm64",
+					Properties:        map[string]string{"InvariantGlobalization": "true", "DefineConstants": "A;B"},
+					Profile:           "FolderProfile",
+					Verbosity:         "minimal",
+				}
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args[3:]).To(Equal([]string{
+						"-o", filepath.Join(depsDir, depsIdx, "dotnet_publish"),
+						"-c", "Staging",
+						"--self-contained",
+						"-r", "linux-arm64",
+						"-p:PublishProfile=FolderProfile",
+						"-p:DefineConstants=A%3BB",
+						"-p:InvariantGlobalization=true",
+						"-v", "minimal",
+					}))
+				})
+				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("configuration: Staging"))
+				Expect(buffer.String()).To(ContainSubstring("runtime: linux-arm64 (self-contained: true)"))
+				Expect(buffer.String()).To(ContainSubstring("property: InvariantGlobalization=true"))
+			})
+
+			It("Turns on the ready-to-run, trimmed and single-file options", func() {
+				finalizer.Config.Publish = config.Publish{ReadyToRun: true, Trimmed: true, SingleFile: true}
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).To(ContainElements("-p:PublishReadyToRun=true", "-p:PublishSingleFile=true", "-p:PublishTrimmed=true"))
+				})
+				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("PublishTrimmed: true"))
+			})
+
+			Context("The project has a packages.lock.json", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "lib"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><ItemGroup><ProjectReference Include="lib/lib.csproj" /></ItemGroup></Project>`), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "lib", "lib.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk" />`), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "lib", "packages.lock.json"), []byte(`{"version": 1}`), 0644)).To(Succeed())
+				})
+
+				It("Restores in locked mode", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(ContainElement("-p:RestoreLockedMode=true"))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+
+				It("Does not restore in locked mode when it is turned off", func() {
+					lockedMode := false
+					finalizer.Config.Publish.LockedMode = &lockedMode
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).NotTo(ContainElement("-p:RestoreLockedMode=true"))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+
+				It("Names the projects whose lock file is out of date", func() {
+					libProject := filepath.Join(buildDir, "lib", "lib.csproj")
+					mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+						fmt.Fprintf(cmd.Stdout, "%[1]s : error NU1004: The package reference Newtonsoft.Json version has changed from [12.0.1, ) to [13.0.3, ). [%[1]s]\n", libProject)
+						fmt.Fprintf(cmd.Stdout, "%[1]s : error NU1004: The package reference Newtonsoft.Json version has changed from [12.0.1, ) to [13.0.3, ). [%[1]s]\n", libProject)
+						return errors.New("exit status 1")
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(MatchError("the NuGet lock file is out of date for 1 project(s):\n" +
+						"  lib/lib.csproj: The package reference Newtonsoft.Json version has changed from [12.0.1, ) to [13.0.3, ).\n" +
+						"Run 'dotnet restore --force-evaluate' and commit the updated packages.lock.json files, or set dotnet-core.publish.locked-mode to false in buildpack.yml"))
+				})
+			})
+
+			It("Publishes a framework-dependent app when it is requested", func() {
+				finalizer.Config.FrameworkDependent = true
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).To(ContainElements("--self-contained", "false", "-r", "linux-x64"))
+				})
+				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			})
 		})
 	})
 
@@ -124,4 +229,847 @@ var _ = Describe("Finalize", func() {
 			})
 		})
 	})
+
+	Describe("Memory tuning", func() {
+		var launch func(env ...string) map[string]string
+
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app.runtimeconfig.json"), []byte(`{"runtimeOptions": {"configProperties": {"System.GC.Server": true}}}`), 0644)).To(Succeed())
+
+			binDir := filepath.Join(buildDir, "fake-bin")
+			Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(binDir, "nproc"), []byte("#!/bin/sh\necho 16\n"), 0755)).To(Succeed())
+
+			launch = func(env ...string) map[string]string {
+				Expect(finalizer.WriteProfileD()).To(Succeed())
+				cmd := exec.Command("sh", "-c", ". "+filepath.Join(depsDir, depsIdx, "profile.d", "dotnet-memory.sh")+" && env")
+				cmd.Env = append([]string{"PATH=" + binDir + ":" + os.Getenv("PATH")}, env...)
+				output, err := cmd.Output()
+				Expect(err).NotTo(HaveOccurred())
+
+				vars := map[string]string{}
+				for _, line := range strings.Split(string(output), "\n") {
+					if name, value, found := strings.Cut(line, "="); found && strings.HasPrefix(name, "DOTNET_") {
+						vars[name] = value
+					}
+				}
+				return vars
+			}
+		})
+
+		It("limits the heap and keeps server GC with one heap per CPU", func() {
+			Expect(launch("MEMORY_LIMIT=4G")).To(Equal(map[string]string{
+				"DOTNET_GCHeapHardLimit":                  "0xc0000000",
+				"DOTNET_gcServer":                         "1",
+				"DOTNET_GCHeapCount":                      "0x4",
+				"DOTNET_GCNoAffinitize":                   "1",
+				"DOTNET_ThreadPool_ForceMinWorkerThreads": "0x10",
+			}))
+		})
+
+		It("uses workstation GC in small containers", func() {
+			Expect(launch("MEMORY_LIMIT=512M")).To(Equal(map[string]string{
+				"DOTNET_GCHeapHardLimit":                  "0x18000000",
+				"DOTNET_gcServer":                         "0",
+				"DOTNET_ThreadPool_ForceMinWorkerThreads": "0x4",
+			}))
+		})
+
+		It("leaves the settings of the app's environment alone", func() {
+			vars := launch("MEMORY_LIMIT=4G", "DOTNET_gcServer=0", "COMPlus_GCHeapHardLimitPercent=0x32")
+			Expect(vars).To(HaveKeyWithValue("DOTNET_gcServer", "0"))
+			Expect(vars).NotTo(HaveKey("DOTNET_GCHeapHardLimit"))
+		})
+
+		It("does nothing without a memory limit", func() {
+			Expect(launch()).To(BeEmpty())
+		})
+
+		It("reads the runtimeconfig.json of the main project when the app references another Exe project", func() {
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "tool.runtimeconfig.json"), []byte(`{"runtimeOptions": {}}`), 0644)).To(Succeed())
+			Expect(launch("MEMORY_LIMIT=4G")).To(HaveKeyWithValue("DOTNET_gcServer", "1"))
+		})
+
+		It("uses workstation GC when the runtimeconfig.json of the app cannot be found", func() {
+			Expect(os.Rename(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app.runtimeconfig.json"), filepath.Join(depsDir, depsIdx, "dotnet_publish", "other.runtimeconfig.json"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "tool.runtimeconfig.json"), []byte(`{"runtimeOptions": {}}`), 0644)).To(Succeed())
+			Expect(launch("MEMORY_LIMIT=4G")).NotTo(HaveKey("DOTNET_gcServer"))
+		})
+	})
+
+	Describe("Native AOT apps", func() {
+		BeforeEach(func() {
+			finalizer.Config.NativeAot = true
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><PublishAot>true</PublishAot></PropertyGroup></Project>`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app"), []byte("native"), 0755)).To(Succeed())
+			for _, dir := range []string{"bin", "lib", "dotnet-sdk/shared/Microsoft.NETCore.App/8.0.0"} {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, dir), 0755)).To(Succeed())
+			}
+		})
+
+		It("removes the SDK and runtime from the droplet", func() {
+			Expect(finalizer.CleanStagingArea()).To(Succeed())
+			Expect(filepath.Join(depsDir, depsIdx, "dotnet-sdk")).NotTo(BeADirectory())
+		})
+
+		It("does not point DOTNET_ROOT at the removed SDK", func() {
+			Expect(finalizer.WriteProfileD()).To(Succeed())
+			contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "startup.sh"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring("ASPNETCORE_URLS"))
+			Expect(string(contents)).NotTo(ContainSubstring("DOTNET_ROOT"))
+		})
+
+		It("starts the native executable", func() {
+			release, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(release["default_process_types"]["web"]).To(Equal(fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish && exec ./app", depsIdx)))
+		})
+	})
+
+	Describe("RestoreLocalTools", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "app"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "app", "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+		})
+
+		It("does nothing when the app has no tool manifest", func() {
+			Expect(finalizer.RestoreLocalTools()).To(Succeed())
+		})
+
+		Context("the app has a tool manifest", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, ".config"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, ".config", "dotnet-tools.json"), []byte(`{
+  "version": 1,
+  "isRoot": true,
+  "tools": {
+    "dotnet-ef": {"version": "8.0.4", "commands": ["dotnet-ef"]},
+    "swashbuckle.aspnetcore.cli": {"version": "6.5.0", "commands": ["swagger"]}
+  }
+}`), 0644)).To(Succeed())
+			})
+
+			It("restores the tools and puts their commands on the PATH", func() {
+				toolsBinDir := filepath.Join(depsDir, depsIdx, "dotnet-tools", "bin")
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).To(Equal([]string{"dotnet", "tool", "restore"}))
+					Expect(cmd.Dir).To(Equal(buildDir))
+					Expect(cmd.Env).To(ContainElement("HOME=" + filepath.Join(depsDir, depsIdx)))
+				})
+				Expect(finalizer.RestoreLocalTools()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Tools: dotnet-ef 8.0.4, swashbuckle.aspnetcore.cli 6.5.0"))
+
+				script, err := os.ReadFile(filepath.Join(toolsBinDir, "swagger"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(script)).To(Equal("#!/bin/sh\nexec dotnet tool run swagger \"$@\"\n"))
+				Expect(filepath.Join(toolsBinDir, "dotnet-ef")).To(BeARegularFile())
+
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Env[len(cmd.Env)-1]).To(HavePrefix("PATH=" + filepath.Join(buildDir, "src", "app", "node_modules", ".bin") + ":" + toolsBinDir + ":"))
+				})
+				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			})
+
+			It("restores the tools from the vendored packages only", func() {
+				vendorDir := filepath.Join(buildDir, ".nuget-packages")
+				Expect(os.MkdirAll(vendorDir, 0755)).To(Succeed())
+
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args[:4]).To(Equal([]string{"dotnet", "tool", "restore", "--configfile"}))
+					Expect(cmd.Args).NotTo(ContainElement("--ignore-failed-sources"))
+
+					config, err := os.ReadFile(cmd.Args[4])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(config)).To(ContainSubstring(`<packageSources>
+    <clear></clear>
+    <add key=".nuget-packages" value="` + vendorDir + `"></add>
+  </packageSources>`))
+				})
+				Expect(finalizer.RestoreLocalTools()).To(Succeed())
+			})
+		})
+	})
+
+	Describe("RunPublishHook", func() {
+		var hookPath string
+
+		BeforeEach(func() {
+			hookPath = filepath.Join(buildDir, ".dotnet-buildpack", "pre-publish")
+			Expect(os.MkdirAll(filepath.Dir(hookPath), 0755)).To(Succeed())
+		})
+
+		It("does nothing when the app has no hook", func() {
+			Expect(finalizer.RunPublishHook(finalize.PostPublishHook)).To(Succeed())
+		})
+
+		It("runs the hook from the app directory with the staging environment", func() {
+			Expect(os.WriteFile(hookPath, []byte("#!/bin/sh\nnpm run build\n"), 0755)).To(Succeed())
+			mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+				Expect(cmd.Path).To(Equal(hookPath))
+				Expect(cmd.Dir).To(Equal(buildDir))
+				Expect(cmd.Env).To(ContainElements(
+					"HOME="+filepath.Join(depsDir, depsIdx),
+					"DOTNET_PUBLISH_DIR="+filepath.Join(depsDir, depsIdx, "dotnet_publish"),
+				))
+			})
+			Expect(finalizer.RunPublishHook(finalize.PrePublishHook)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Running .dotnet-buildpack/pre-publish"))
+		})
+
+		It("fails when the hook fails", func() {
+			Expect(os.WriteFile(hookPath, []byte("#!/bin/sh\nexit 3\n"), 0755)).To(Succeed())
+			mockCommand.EXPECT().Run(gomock.Any()).Return(errors.New("exit status 3"))
+			Expect(finalizer.RunPublishHook(finalize.PrePublishHook)).To(MatchError(".dotnet-buildpack/pre-publish failed: exit status 3"))
+		})
+
+		It("fails when the hook is not executable", func() {
+			Expect(os.WriteFile(hookPath, []byte("#!/bin/sh\n"), 0644)).To(Succeed())
+			Expect(finalizer.RunPublishHook(finalize.PrePublishHook)).To(MatchError(".dotnet-buildpack/pre-publish is not executable, make it executable with 'chmod +x .dotnet-buildpack/pre-publish' and push again"))
+		})
+	})
+
+	Describe("RunTests", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "tests"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "tests", "app.tests.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk">
+  <ItemGroup><PackageReference Include="Microsoft.NET.Test.Sdk" Version="17.9.0" /></ItemGroup>
+</Project>`), 0644)).To(Succeed())
+		})
+
+		It("does not run tests by default", func() {
+			Expect(finalizer.RunTests()).To(Succeed())
+		})
+
+		Context("the test gate is enabled", func() {
+			writeResults := func(cmd *exec.Cmd, failed int) {
+				var resultsDir string
+				for i, arg := range cmd.Args {
+					if arg == "--results-directory" {
+						resultsDir = cmd.Args[i+1]
+					}
+				}
+				Expect(os.MkdirAll(resultsDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(resultsDir, "results.trx"), []byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<TestRun xmlns="http://microsoft.com/schemas/VisualStudio/TeamTest/2010">
+  <Results>
+    <UnitTestResult testName="AppTests.Passes" outcome="Passed" />
+    <UnitTestResult testName="AppTests.Fails" outcome="Failed">
+      <Output><ErrorInfo><Message>Assert.Equal() Failure
+Expected: 1</Message></ErrorInfo></Output>
+    </UnitTestResult>
+  </Results>
+  <ResultSummary outcome="Failed">
+    <Counters total="3" executed="2" passed="%d" failed="%d" notExecuted="1" />
+  </ResultSummary>
+</TestRun>`, 2-failed, failed)), 0644)).To(Succeed())
+			}
+
+			BeforeEach(func() {
+				finalizer.Config.Test.Enabled = true
+			})
+
+			It("runs dotnet test on the test projects and summarizes the results", func() {
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args[:3]).To(Equal([]string{"dotnet", "test", filepath.Join(buildDir, "tests", "app.tests.csproj")}))
+					writeResults(cmd, 0)
+				})
+				Expect(finalizer.RunTests()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("tests/app.tests.csproj: 3 total, 2 passed, 0 failed, 1 skipped"))
+			})
+
+			It("restores from the vendored packages, in locked mode when the test project has a lock file", func() {
+				vendorDir := filepath.Join(buildDir, ".nuget-packages")
+				Expect(os.MkdirAll(vendorDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "tests", "packages.lock.json"), []byte(`{"version": 1}`), 0644)).To(Succeed())
+
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).To(ContainElements("-p:RestoreLockedMode=true", "-p:RestoreSources="+vendorDir, "-p:NuGetAudit=false"))
+					writeResults(cmd, 0)
+				})
+				Expect(finalizer.RunTests()).To(Succeed())
+			})
+
+			It("does not restore in locked mode for the lock file of the main project only", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte(`{"version": 1}`), 0644)).To(Succeed())
+
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args).NotTo(ContainElement("-p:RestoreLockedMode=true"))
+					writeResults(cmd, 0)
+				})
+				Expect(finalizer.RunTests()).To(Succeed())
+			})
+
+			It("reports the test projects whose lock file is out of date", func() {
+				lockedMode := true
+				finalizer.Config.Publish.LockedMode = &lockedMode
+				testProject := filepath.Join(buildDir, "tests", "app.tests.csproj")
+
+				mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+					Expect(cmd.Args).To(ContainElement("-p:RestoreLockedMode=true"))
+					fmt.Fprintf(cmd.Stdout, "%s : error NU1004: The package reference Microsoft.NET.Test.Sdk version has changed. [%s]\n", testProject, testProject)
+					return errors.New("exit status 1")
+				})
+				Expect(finalizer.RunTests()).To(MatchError(HavePrefix("the NuGet lock file is out of date for 1 project(s):\n" +
+					"  tests/app.tests.csproj: The package reference Microsoft.NET.Test.Sdk version has changed.\n")))
+			})
+
+			It("fails when tests fail", func() {
+				finalizer.Config.Test.Filter = "Category!=Integration"
+				mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+					Expect(cmd.Args).To(ContainElements("--filter", "Category!=Integration"))
+					writeResults(cmd, 1)
+					return errors.New("exit status 1")
+				})
+				Expect(finalizer.RunTests()).To(MatchError("tests failed in tests/app.tests.csproj (1 failed)"))
+				Expect(buffer.String()).To(ContainSubstring("FAILED AppTests.Fails: Assert.Equal() Failure\n"))
+			})
+		})
+	})
+
+	Describe("Extra process types", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Api"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Worker"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Jobs"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Api", "Api.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Worker", "Worker.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Worker"><PropertyGroup><TargetFrameworks>net8.0;net9.0</TargetFrameworks></PropertyGroup></Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Jobs", "Jobs.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><OutputType>Exe</OutputType><AssemblyName>Company.Jobs</AssemblyName></PropertyGroup></Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[config]\nproject = src/Api/Api.csproj"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("jobs: src/Jobs/Jobs.csproj\nclock: ./clock.sh\n"), 0644)).To(Succeed())
+
+			finalizer.Config.TargetFramework = "net8.0"
+			finalizer.Config.Processes = map[string]string{"worker": "src/Worker/Worker.csproj"}
+		})
+
+		It("publishes each project into its own directory", func() {
+			var published [][]string
+			mockCommand.EXPECT().Run(gomock.Any()).Times(3).Do(func(cmd *exec.Cmd) {
+				published = append(published, cmd.Args[2:5])
+				if cmd.Args[2] == filepath.Join(buildDir, "src", "Jobs", "Jobs.csproj") {
+					Expect(cmd.Args).NotTo(ContainElement("-f"))
+				}
+			})
+			Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			Expect(published).To(Equal([][]string{
+				{filepath.Join(buildDir, "src", "Api", "Api.csproj"), "-o", filepath.Join(depsDir, depsIdx, "dotnet_publish")},
+				{filepath.Join(buildDir, "src", "Jobs", "Jobs.csproj"), "-o", filepath.Join(depsDir, depsIdx, "processes", "jobs")},
+				{filepath.Join(buildDir, "src", "Worker", "Worker.csproj"), "-o", filepath.Join(depsDir, depsIdx, "processes", "worker")},
+			}))
+		})
+
+		Context("the projects are published", func() {
+			BeforeEach(func() {
+				for _, path := range []string{"dotnet_publish/Api", "processes/worker/Worker", "processes/jobs/Company.Jobs"} {
+					Expect(os.MkdirAll(filepath.Dir(filepath.Join(depsDir, depsIdx, path)), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, path), []byte("app"), 0755)).To(Succeed())
+				}
+			})
+
+			It("adds a release entry for each process without writing the Procfile", func() {
+				release, err := finalizer.GenerateReleaseYaml()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(release["default_process_types"]).To(Equal(map[string]string{
+					"web":    fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish && exec ./Api", depsIdx),
+					"worker": fmt.Sprintf("cd ${DEPS_DIR}/%s/processes/worker && exec ./Worker", depsIdx),
+					"jobs":   fmt.Sprintf("cd ${DEPS_DIR}/%s/processes/jobs && exec ./Company.Jobs", depsIdx),
+				}))
+
+				procfile, err := os.ReadFile(filepath.Join(buildDir, "Procfile"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(procfile)).To(Equal("jobs: src/Jobs/Jobs.csproj\nclock: ./clock.sh\n"))
+			})
+
+			It("rewrites the Procfile entries that name a project", func() {
+				Expect(finalizer.RewriteProcfile()).To(Succeed())
+
+				procfile, err := os.ReadFile(filepath.Join(buildDir, "Procfile"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(procfile)).To(Equal(fmt.Sprintf("jobs: cd ${DEPS_DIR}/%s/processes/jobs && exec ./Company.Jobs\nclock: ./clock.sh\n", depsIdx)))
+			})
+		})
+
+		DescribeTable("rejects Procfile projects outside the app",
+			func(projectPath string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("jobs: "+projectPath+"\n"), 0644)).To(Succeed())
+				_, err := finalizer.GenerateReleaseYaml()
+				Expect(err).To(MatchError("process jobs must name a project file in the app, such as src/Worker/Worker.csproj"))
+			},
+			Entry("an absolute path", filepath.Join(os.TempDir(), "Jobs", "Jobs.csproj")),
+			Entry("a path above the app", "../Jobs/Jobs.csproj"),
+			Entry("a path that leaves the app", "src/../../Jobs/Jobs.csproj"),
+		)
+
+		DescribeTable("rejects Procfile projects with an invalid process type",
+			func(procfile, message string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte(procfile), 0644)).To(Succeed())
+				_, err := finalizer.GenerateReleaseYaml()
+				Expect(err).To(MatchError(message))
+			},
+			Entry("the web process", "web: src/Api/Api.csproj\n", "Procfile: the web process runs the app's main project and cannot run another project"),
+			Entry("an invalid name", "my.jobs: src/Jobs/Jobs.csproj\n", "Procfile: invalid process type 'my.jobs'"),
+		)
+
+		It("rejects a process defined in both the Procfile and buildpack.yml", func() {
+			finalizer.Config.Processes["jobs"] = "src/Jobs/Jobs.csproj"
+			_, err := finalizer.GenerateReleaseYaml()
+			Expect(err).To(MatchError("process jobs is defined in both the Procfile and buildpack.yml"))
+		})
+	})
+
+	Describe("Start settings", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish", "content"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app.dll"), []byte("app"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "Fred.Api.dll"), []byte("app"), 0644)).To(Succeed())
+		})
+
+		It("starts the start assembly with its arguments from the working directory", func() {
+			finalizer.Config.Start = config.Start{Assembly: "Fred.Api", Args: "--urls http://0.0.0.0:${PORT}", WorkingDir: "content"}
+			finalizer.Project.SetEntryAssembly("Fred.Api")
+			Expect(finalizer.CheckStartCommand()).To(Succeed())
+
+			release, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(release["default_process_types"]["web"]).To(Equal(
+				fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish/content && exec dotnet ${DEPS_DIR}/%s/dotnet_publish/Fred.Api.dll --urls http://0.0.0.0:${PORT}", depsIdx, depsIdx)))
+		})
+
+		It("adds the arguments to the default start command", func() {
+			finalizer.Config.Start = config.Start{Args: "--contentRoot ."}
+			release, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(release["default_process_types"]["web"]).To(Equal(fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish && exec dotnet ./app.dll --contentRoot .", depsIdx)))
+		})
+
+		It("fails when the start assembly was not published", func() {
+			finalizer.Config.Start = config.Start{Assembly: "Fred.Web"}
+			finalizer.Project.SetEntryAssembly("Fred.Web")
+			Expect(finalizer.CheckStartCommand()).To(MatchError("start assembly Fred.Web not found in the published app, it has neither Fred.Web nor Fred.Web.dll"))
+		})
+
+		It("fails when the start assembly was not published, even next to a single-file bundle", func() {
+			bundle := []byte("\x7fELF host\x10\x2f\x4a\x00\x00\x00\x00\x00")
+			bundle = append(bundle, 0x8b, 0x12, 0x02, 0xb9, 0x6a, 0x61, 0x20, 0x38, 0x72, 0x7b, 0x93, 0x02, 0x14, 0xd7, 0xa0, 0x32,
+				0x13, 0xf5, 0xb9, 0xe6, 0xef, 0xae, 0x33, 0x18, 0xee, 0x3b, 0x2d, 0xce, 0x24, 0xb3, 0x6a, 0xae)
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app"), bundle, 0755)).To(Succeed())
+
+			finalizer.Config.Start = config.Start{Assembly: "Fred.Apii"}
+			finalizer.Project.SetEntryAssembly("Fred.Apii")
+			Expect(finalizer.CheckStartCommand()).To(MatchError("start assembly Fred.Apii not found in the published app, it has neither Fred.Apii nor Fred.Apii.dll"))
+		})
+
+		It("fails when the working directory was not published", func() {
+			finalizer.Config.Start = config.Start{WorkingDir: "wwwroot"}
+			Expect(finalizer.CheckStartCommand()).To(MatchError("start working-dir wwwroot is not a directory in the published app"))
+		})
+	})
+
+	Describe("BuildMigrationsBundle", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Data"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web">
+  <ItemGroup><PackageReference Include="Microsoft.EntityFrameworkCore.Design" Version="8.0.4" /></ItemGroup>
+</Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Data", "Data.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk" />`), 0644)).To(Succeed())
+		})
+
+		It("does not build a bundle by default", func() {
+			Expect(finalizer.BuildMigrationsBundle(stackRID)).To(Succeed())
+		})
+
+		Context("the migrations bundle is enabled", func() {
+			BeforeEach(func() {
+				finalizer.Config.Migrations = config.Migrations{Enabled: true, Process: "migrate", Project: "src/Data/Data.csproj", Context: "AppDbContext"}
+			})
+
+			It("installs the matching dotnet-ef and builds a self-contained bundle", func() {
+				toolsBin := filepath.Join(depsDir, depsIdx, "dotnet-tools", "bin")
+				gomock.InOrder(
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(Equal([]string{"dotnet", "tool", "install", "dotnet-ef", "--tool-path", toolsBin, "--version", "8.0.4"}))
+					}),
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Path).To(Equal(filepath.Join(toolsBin, "dotnet-ef")))
+						Expect(cmd.Dir).To(Equal(buildDir))
+						Expect(cmd.Args[1:]).To(Equal([]string{
+							"migrations", "bundle",
+							"--self-contained",
+							"-r", "linux-x64",
+							"-o", filepath.Join(depsDir, depsIdx, "migrations", "efbundle"),
+							"--project", filepath.Join(buildDir, "src", "Data", "Data.csproj"),
+							"--startup-project", filepath.Join(buildDir, "app.csproj"),
+							"--configuration", "Debug",
+							"--force",
+							"--context", "AppDbContext",
+						}))
+					}),
+				)
+				Expect(finalizer.BuildMigrationsBundle(stackRID)).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Building Entity Framework migrations bundle"))
+			})
+
+			It("uses the dotnet-ef local tool when the app has one", func() {
+				toolsBin := filepath.Join(depsDir, depsIdx, "dotnet-tools", "bin")
+				Expect(os.MkdirAll(toolsBin, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(toolsBin, "dotnet-ef"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+					Expect(cmd.Args[1:3]).To(Equal([]string{"migrations", "bundle"}))
+				})
+				Expect(finalizer.BuildMigrationsBundle(stackRID)).To(Succeed())
+			})
+
+			It("installs dotnet-ef from the vendored packages and restores the bundle like dotnet publish", func() {
+				vendorDir := filepath.Join(buildDir, ".nuget-packages")
+				Expect(os.MkdirAll(vendorDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte(`{"version": 1}`), 0644)).To(Succeed())
+
+				gomock.InOrder(
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args[:3]).To(Equal([]string{"dotnet", "tool", "install"}))
+						Expect(cmd.Args[len(cmd.Args)-2]).To(Equal("--configfile"))
+
+						config, err := os.ReadFile(cmd.Args[len(cmd.Args)-1])
+						Expect(err).NotTo(HaveOccurred())
+						Expect(string(config)).To(ContainSubstring(`<clear></clear>
+    <add key=".nuget-packages" value="` + vendorDir + `"></add>`))
+					}),
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args[1:3]).To(Equal([]string{"migrations", "bundle"}))
+						Expect(cmd.Env).To(ContainElements("RestoreLockedMode=true", "RestoreSources="+vendorDir, "NuGetAudit=false"))
+					}),
+				)
+				Expect(finalizer.BuildMigrationsBundle(stackRID)).To(Succeed())
+			})
+
+			It("adds a release entry that runs the bundle from the published app", func() {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app"), []byte("app"), 0755)).To(Succeed())
+
+				release, err := finalizer.GenerateReleaseYaml()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(release["default_process_types"]).To(HaveKeyWithValue("migrate",
+					fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish && exec ${DEPS_DIR}/%s/migrations/efbundle", depsIdx, depsIdx)))
+			})
+		})
+	})
+
+	Describe("ConfigureNuGetFeeds", func() {
+		var nugetConfigPath string
+
+		BeforeEach(func() {
+			nugetConfigPath = filepath.Join(depsDir, depsIdx, ".nuget", "NuGet", "NuGet.Config")
+		})
+
+		Context("no feeds are bound", func() {
+			It("does not write a NuGet.Config", func() {
+				Expect(finalizer.ConfigureNuGetFeeds()).To(Succeed())
+				Expect(nugetConfigPath).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("a service tagged nuget is bound", func() {
+			BeforeEach(func() {
+				vcapServices := `{"user-provided": [
+					{"name": "artifactory", "tags": ["nuget"], "credentials": {"url": "https://artifactory.example.com/api/nuget/v3/nuget", "username": "build", "password": "s3cr&t"}},
+					{"name": "database", "tags": ["mysql"], "credentials": {"url": "mysql://db"}}
+				]}`
+				Expect(os.Setenv("VCAP_SERVICES", vcapServices)).To(Succeed())
+				DeferCleanup(os.Unsetenv, "VCAP_SERVICES")
+				Expect(os.Setenv("NUGET_FEED_AZURE_URL", "https://pkgs.dev.azure.com/org/_packaging/feed/nuget/v3/index.json")).To(Succeed())
+				DeferCleanup(os.Unsetenv, "NUGET_FEED_AZURE_URL")
+			})
+
+			It("writes a NuGet.Config with the feeds and their credentials", func() {
+				Expect(finalizer.ConfigureNuGetFeeds()).To(Succeed())
+
+				content, err := os.ReadFile(nugetConfigPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(ContainSubstring(`<add key="nuget.org" value="https://api.nuget.org/v3/index.json"></add>`))
+				Expect(string(content)).To(ContainSubstring(`<add key="artifactory" value="https://artifactory.example.com/api/nuget/v3/nuget"></add>`))
+				Expect(string(content)).To(ContainSubstring(`<add key="azure" value="https://pkgs.dev.azure.com/org/_packaging/feed/nuget/v3/index.json"></add>`))
+				Expect(string(content)).To(ContainSubstring(`<artifactory>`))
+				Expect(string(content)).To(ContainSubstring(`<add key="ClearTextPassword" value="s3cr&amp;t"></add>`))
+				Expect(string(content)).NotTo(ContainSubstring("mysql"))
+				Expect(buffer.String()).To(ContainSubstring("Using NuGet feeds: artifactory, azure"))
+				Expect(buffer.String()).NotTo(ContainSubstring("s3cr"))
+			})
+
+			It("names the unnamed feeds of a service after its position", func() {
+				Expect(os.Setenv("VCAP_SERVICES", `{"user-provided": [
+					{"name": "artifactory", "tags": ["nuget"], "credentials": {"url": "https://artifactory.example.com/nuget/main", "feeds": [
+						{"url": "https://artifactory.example.com/nuget/team-a"},
+						{"url": "https://artifactory.example.com/nuget/team-b"}
+					]}}
+				]}`)).To(Succeed())
+
+				Expect(finalizer.ConfigureNuGetFeeds()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Using NuGet feeds: artifactory, artifactory_1, artifactory_2, azure"))
+			})
+
+			It("rejects feeds with the same name", func() {
+				Expect(os.Setenv("VCAP_SERVICES", `{"user-provided": [
+					{"name": "artifactory", "tags": ["nuget"], "credentials": {"feeds": [
+						{"name": "Azure", "url": "https://artifactory.example.com/nuget/azure"}
+					]}}
+				]}`)).To(Succeed())
+
+				Expect(finalizer.ConfigureNuGetFeeds()).To(MatchError("more than one NuGet feed is named azure, give each feed a different name"))
+			})
+
+			It("ignores brokered services tagged nuget", func() {
+				Expect(os.Setenv("VCAP_SERVICES", `{"artifactory": [
+					{"name": "brokered", "tags": ["nuget"], "credentials": {"url": "https://artifactory.example.com/nuget/main"}}
+				]}`)).To(Succeed())
+
+				Expect(finalizer.ConfigureNuGetFeeds()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Using NuGet feeds: azure"))
+			})
+
+			It("is removed by CleanStagingArea", func() {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "bin"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "lib"), 0755)).To(Succeed())
+				Expect(finalizer.ConfigureNuGetFeeds()).To(Succeed())
+				Expect(finalizer.CleanStagingArea()).To(Succeed())
+				Expect(nugetConfigPath).NotTo(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("NuGet package cache", func() {
+		var (
+			packagesDir string
+			nextStaging func() *finalize.Finalizer
+		)
+
+		BeforeEach(func() {
+			packagesDir = filepath.Join(depsDir, depsIdx, ".nuget", "packages")
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte(`{"version": 1}`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(packagesDir, "newtonsoft.json", "13.0.3"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(packagesDir, "newtonsoft.json", "13.0.3", "newtonsoft.json.nuspec"), []byte("nuspec"), 0644)).To(Succeed())
+
+			nextStaging = func() *finalize.Finalizer {
+				return &finalize.Finalizer{
+					Stager:  finalizer.Stager,
+					Command: finalizer.Command,
+					Log:     finalizer.Log,
+					Project: finalizer.Project,
+					Config:  finalizer.Config,
+				}
+			}
+		})
+
+		It("saves the packages and restores them when the inputs did not change", func() {
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+			Expect(packagesDir).NotTo(BeADirectory())
+			Expect(buffer.String()).To(ContainSubstring("Saving NuGet packages to cache"))
+
+			Expect(nextStaging().RestoreNuGetPackages()).To(Succeed())
+			Expect(filepath.Join(packagesDir, "newtonsoft.json", "13.0.3", "newtonsoft.json.nuspec")).To(BeARegularFile())
+			Expect(buffer.String()).To(ContainSubstring("Restoring NuGet packages from cache"))
+		})
+
+		It("keeps the cache for the next staging when this one fails after restoring it", func() {
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+			Expect(nextStaging().RestoreNuGetPackages()).To(Succeed())
+			Expect(os.RemoveAll(packagesDir)).To(Succeed())
+
+			Expect(nextStaging().RestoreNuGetPackages()).To(Succeed())
+			Expect(filepath.Join(packagesDir, "newtonsoft.json", "13.0.3", "newtonsoft.json.nuspec")).To(BeARegularFile())
+		})
+
+		It("keeps the cache when publish wrote files to obj", func() {
+			Expect(finalizer.RestoreNuGetPackages()).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "obj"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "obj", "project.assets.json"), []byte(`{"version": 3}`), 0644)).To(Succeed())
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+
+			Expect(os.RemoveAll(filepath.Join(buildDir, "obj"))).To(Succeed())
+			Expect(nextStaging().RestoreNuGetPackages()).To(Succeed())
+			Expect(filepath.Join(packagesDir, "newtonsoft.json", "13.0.3", "newtonsoft.json.nuspec")).To(BeARegularFile())
+			Expect(buffer.String()).NotTo(ContainSubstring("NuGet package cache is out of date"))
+		})
+
+		It("does not restore the packages when the lock file changed", func() {
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte(`{"version": 2}`), 0644)).To(Succeed())
+
+			Expect(nextStaging().RestoreNuGetPackages()).To(Succeed())
+			Expect(packagesDir).NotTo(BeADirectory())
+			Expect(filepath.Join(cacheDir, "nuget")).NotTo(BeADirectory())
+			Expect(buffer.String()).To(ContainSubstring("NuGet package cache is out of date"))
+		})
+
+		It("clears the cache when CLEAR_NUGET_CACHE is true", func() {
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+			Expect(os.Setenv("CLEAR_NUGET_CACHE", "true")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "CLEAR_NUGET_CACHE")
+
+			Expect(nextStaging().RestoreNuGetPackages()).To(Succeed())
+			Expect(packagesDir).NotTo(BeADirectory())
+			Expect(filepath.Join(cacheDir, "nuget")).NotTo(BeADirectory())
+		})
+
+		It("only warns when the packages cannot be saved", func() {
+			Expect(os.Setenv("NUGET_CACHE_MAX_SIZE_MB", "lots")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "NUGET_CACHE_MAX_SIZE_MB")
+
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Unable to save NuGet packages to cache: invalid value 'lots' for NUGET_CACHE_MAX_SIZE_MB"))
+		})
+
+		It("does not save packages larger than NUGET_CACHE_MAX_SIZE_MB", func() {
+			Expect(os.Setenv("NUGET_CACHE_MAX_SIZE_MB", "0")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "NUGET_CACHE_MAX_SIZE_MB")
+			Expect(os.WriteFile(filepath.Join(packagesDir, "large.nupkg"), make([]byte, 2*1024*1024), 0644)).To(Succeed())
+
+			Expect(finalizer.SaveNuGetPackages()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "nuget")).NotTo(BeADirectory())
+			Expect(buffer.String()).To(ContainSubstring("NuGet packages (2 MB) exceed the cache size limit of 0 MB"))
+		})
+	})
+
+	Describe("Vendored NuGet packages", func() {
+		var vendorDir string
+
+		BeforeEach(func() {
+			vendorDir = filepath.Join(buildDir, ".nuget-packages")
+			Expect(os.MkdirAll(filepath.Join(vendorDir, "serilog", "3.1.1"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(vendorDir, "Newtonsoft.Json.13.0.3.nupkg"), []byte("nupkg"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(vendorDir, "serilog", "3.1.1", "serilog.3.1.1.nupkg"), []byte("nupkg"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web">
+  <ItemGroup>
+    <PackageReference Include="Newtonsoft.Json" Version="13.0.3" />
+    <PackageReference Include="Serilog" Version="3.0.0" />
+  </ItemGroup>
+</Project>`), 0644)).To(Succeed())
+		})
+
+		It("restores from the vendored folder only", func() {
+			Expect(finalizer.CheckVendoredPackages(stackRID)).To(Succeed())
+			mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+				Expect(cmd.Args).To(ContainElements("-p:RestoreSources="+vendorDir, "-p:NuGetAudit=false"))
+			})
+			Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Restoring NuGet packages from .nuget-packages only (2 packages)"))
+		})
+
+		It("reports every package missing from the vendored folder", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web">
+  <ItemGroup>
+    <PackageReference Include="Newtonsoft.Json" Version="13.0.3" />
+    <PackageReference Include="Serilog" Version="4.0.0" />
+    <PackageReference Include="Dapper" Version="2.1.35" />
+  </ItemGroup>
+</Project>`), 0644)).To(Succeed())
+
+			Expect(finalizer.CheckVendoredPackages(stackRID)).To(MatchError(".nuget-packages is missing 2 NuGet package(s) the app needs:\n" +
+				"  Dapper 2.1.35\n" +
+				"  Serilog 4.0.0\n" +
+				"Add the .nupkg files of these packages to .nuget-packages, for example from the NuGet global packages folder after running 'dotnet restore'"))
+		})
+
+		It("checks the resolved packages of the lock files", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte(`{
+  "version": 1,
+  "dependencies": {
+    "net8.0": {
+      "Newtonsoft.Json": {"type": "Direct", "requested": "[13.0.3, )", "resolved": "13.0.3"},
+      "Serilog": {"type": "Direct", "requested": "[3.0.0, )", "resolved": "3.1.1"},
+      "System.Memory": {"type": "Transitive", "resolved": "4.5.5"}
+    },
+    "net8.0/linux-x64": {
+      "Microsoft.NETCore.App.Runtime.linux-x64": {"type": "Direct", "requested": "[8.0.0, )", "resolved": "8.0.0"}
+    },
+    "net8.0/linux-arm64": {
+      "Microsoft.NETCore.App.Runtime.linux-arm64": {"type": "Direct", "requested": "[8.0.0, )", "resolved": "8.0.0"}
+    }
+  }
+}`), 0644)).To(Succeed())
+
+			err := finalizer.CheckVendoredPackages(stackRID)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix(".nuget-packages is missing 2 NuGet package(s) the app needs:\n" +
+				"  Microsoft.NETCore.App.Runtime.linux-x64 8.0.0\n" +
+				"  System.Memory 4.5.5\n"))
+		})
+
+		Context("the SDK restores implicit packs", func() {
+			BeforeEach(func() {
+				sdkDir := filepath.Join(depsDir, depsIdx, "dotnet-sdk", "sdk", "8.0.204")
+				Expect(os.MkdirAll(sdkDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(sdkDir, "Microsoft.NETCoreSdk.BundledVersions.props"), []byte(`<Project>
+  <ItemGroup>
+    <KnownFrameworkReference Include="Microsoft.NETCore.App" TargetFramework="net8.0" LatestRuntimeFrameworkVersion="8.0.4" RuntimePackNamePatterns="Microsoft.NETCore.App.Runtime.**RID**" />
+    <KnownFrameworkReference Include="Microsoft.AspNetCore.App" TargetFramework="net8.0" LatestRuntimeFrameworkVersion="8.0.4" RuntimePackNamePatterns="Microsoft.AspNetCore.App.Runtime.**RID**" />
+    <KnownFrameworkReference Include="Microsoft.WindowsDesktop.App" TargetFramework="net8.0" LatestRuntimeFrameworkVersion="8.0.4" RuntimePackNamePatterns="Microsoft.WindowsDesktop.App.Runtime.**RID**" />
+    <KnownCrossgen2Pack Include="Microsoft.NETCore.App.Crossgen2" TargetFramework="net8.0" Crossgen2PackNamePattern="Microsoft.NETCore.App.Crossgen2.**RID**" Crossgen2PackVersion="8.0.4" />
+    <KnownILCompilerPack Include="Microsoft.DotNet.ILCompiler" TargetFramework="net8.0" ILCompilerPackNamePattern="runtime.**RID**.Microsoft.DotNet.ILCompiler" ILCompilerPackVersion="8.0.4" />
+  </ItemGroup>
+</Project>`), 0644)).To(Succeed())
+				finalizer.Config.TargetFramework = "net8.0"
+			})
+
+			It("reports the runtime and Crossgen2 packs of a self-contained ReadyToRun app", func() {
+				finalizer.Config.Publish.ReadyToRun = true
+				err := finalizer.CheckVendoredPackages(stackRID)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix(".nuget-packages is missing 3 NuGet package(s) the app needs:\n" +
+					"  Microsoft.AspNetCore.App.Runtime.linux-x64 8.0.4\n" +
+					"  Microsoft.NETCore.App.Crossgen2.linux-x64 8.0.4\n" +
+					"  Microsoft.NETCore.App.Runtime.linux-x64 8.0.4\n"))
+
+				for _, name := range []string{"Microsoft.AspNetCore.App.Runtime.linux-x64", "Microsoft.NETCore.App.Crossgen2.linux-x64", "Microsoft.NETCore.App.Runtime.linux-x64"} {
+					Expect(os.WriteFile(filepath.Join(vendorDir, name+".8.0.4.nupkg"), []byte("nupkg"), 0644)).To(Succeed())
+				}
+				Expect(finalizer.CheckVendoredPackages(stackRID)).To(Succeed())
+			})
+
+			It("reports the ILCompiler packs of a Native AOT app", func() {
+				finalizer.Config.NativeAot = true
+				err := finalizer.CheckVendoredPackages(stackRID)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("  Microsoft.DotNet.ILCompiler 8.0.4\n"))
+				Expect(err.Error()).To(ContainSubstring("  runtime.linux-x64.Microsoft.DotNet.ILCompiler 8.0.4\n"))
+			})
+
+			It("does not need runtime packs for a framework-dependent app", func() {
+				finalizer.Config.FrameworkDependent = true
+				Expect(finalizer.CheckVendoredPackages(stackRID)).To(Succeed())
+			})
+
+			It("reports the packs of an app with lock files along with its locked packages", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte(`{
+  "version": 1,
+  "dependencies": {
+    "net8.0": {
+      "Newtonsoft.Json": {"type": "Direct", "requested": "[13.0.3, )", "resolved": "13.0.3"},
+      "Serilog": {"type": "Direct", "requested": "[3.0.0, )", "resolved": "3.0.0"}
+    }
+  }
+}`), 0644)).To(Succeed())
+
+				err := finalizer.CheckVendoredPackages(stackRID)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix(".nuget-packages is missing 3 NuGet package(s) the app needs:\n" +
+					"  Microsoft.AspNetCore.App.Runtime.linux-x64 8.0.4\n" +
+					"  Microsoft.NETCore.App.Runtime.linux-x64 8.0.4\n" +
+					"  Serilog 3.0.0\n"))
+			})
+		})
+
+		It("ignores bound NuGet feeds", func() {
+			Expect(os.Setenv("NUGET_FEED_URL", "https://nuget.example.com/v3/index.json")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "NUGET_FEED_URL")
+
+			Expect(finalizer.ConfigureNuGetFeeds()).To(Succeed())
+			Expect(filepath.Join(depsDir, depsIdx, ".nuget", "NuGet", "NuGet.Config")).NotTo(BeAnExistingFile())
+		})
+	})
 })
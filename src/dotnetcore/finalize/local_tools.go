@@ -0,0 +1,138 @@
+package finalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type toolManifest struct {
+	Tools map[string]struct {
+		Version  string   `json:"version"`
+		Commands []string `json:"commands"`
+	} `json:"tools"`
+}
+
+func (f *Finalizer) localToolsBinDir() string {
+	return filepath.Join(f.Stager.DepDir(), "dotnet-tools", "bin")
+}
+
+// RestoreLocalTools runs dotnet tool restore for apps with a dotnet-tools.json
+// manifest, so that MSBuild targets and hooks can run tools such as dotnet-ef.
+// The tool packages are restored to the NuGet packages folder under the
+// staging HOME, which SaveNuGetPackages caches, and every tool command gets a
+// script on the PATH of dotnet publish that runs it with dotnet tool run. Apps
+// with a .nuget-packages folder restore their tools from that folder only.
+func (f *Finalizer) RestoreLocalTools() error {
+	manifestPath, err := f.localToolManifestPath()
+	if err != nil || manifestPath == "" {
+		return err
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	var manifest toolManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("could not parse %s: %v", filepath.Base(manifestPath), err)
+	}
+	if len(manifest.Tools) == 0 {
+		return nil
+	}
+
+	var names []string
+	for name := range manifest.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tools []string
+	for _, name := range names {
+		tools = append(tools, name+" "+manifest.Tools[name].Version)
+	}
+
+	f.Log.BeginStep("Restoring .NET tools")
+	f.Log.Info("Tools: %s", strings.Join(tools, ", "))
+
+	// dotnet tool restore finds the manifest from the directory it runs in
+	manifestDir := filepath.Dir(manifestPath)
+	if filepath.Base(manifestDir) == ".config" {
+		manifestDir = filepath.Dir(manifestDir)
+	}
+
+	args := []string{"tool", "restore"}
+	if vendoredPackages, err := f.vendoredPackagesDir(); err != nil {
+		return err
+	} else if vendoredPackages != "" {
+		configPath, cleanup, err := vendoredNuGetConfig(vendoredPackages)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		f.Log.Info("Package source: %s", vendoredPackagesDirName)
+		args = append(args, "--configfile", configPath)
+	}
+
+	cmd := exec.Command("dotnet", args...)
+	cmd.Dir = manifestDir
+	cmd.Env = f.shellEnvironment()
+	cmd.Stdout = indentWriter(os.Stdout)
+	cmd.Stderr = indentWriter(os.Stderr)
+
+	f.Log.Debug("Running command: %v", cmd)
+	if err := f.Command.Run(cmd); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.localToolsBinDir(), 0755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		for _, command := range manifest.Tools[name].Commands {
+			script := fmt.Sprintf("#!/bin/sh\nexec dotnet tool run %s \"$@\"\n", command)
+			if err := os.WriteFile(filepath.Join(f.localToolsBinDir(), command), []byte(script), 0755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// localToolManifestPath returns the dotnet-tools.json that dotnet finds for
+// the main project, looking in its directory and the ones above it up to the
+// app directory, or "" when there is none
+func (f *Finalizer) localToolManifestPath() (string, error) {
+	dir := f.Stager.BuildDir()
+	if mainPath, err := f.Project.MainPath(); err != nil {
+		return "", err
+	} else if mainPath != "" {
+		dir = filepath.Dir(mainPath)
+	}
+
+	buildDir := filepath.Clean(f.Stager.BuildDir())
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		for _, path := range []string{
+			filepath.Join(dir, ".config", "dotnet-tools.json"),
+			filepath.Join(dir, "dotnet-tools.json"),
+		} {
+			if exists, err := libbuildpack.FileExists(path); err != nil {
+				return "", err
+			} else if exists {
+				return path, nil
+			}
+		}
+
+		if dir == buildDir || dir == filepath.Dir(dir) {
+			return "", nil
+		}
+	}
+}
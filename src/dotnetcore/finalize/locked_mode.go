@@ -0,0 +1,70 @@
+package finalize
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// nu1004RE matches the NU1004 errors MSBuild prints as
+// <project> : error NU1004: <message> [<project>]
+var nu1004RE = regexp.MustCompile(`(?m)^\s*(.+?)\s*:\s*error NU1004:\s*(.*?)(?:\s+\[[^\[\]]+proj\])?\s*$`)
+
+// lockedModeLockFiles returns the NuGet lock files of the main project and
+// its project references when publish should restore in locked mode, which
+// is whenever there are any unless dotnet-core.publish.locked-mode is false
+func (f *Finalizer) lockedModeLockFiles() ([]string, error) {
+	lockedMode := f.Config.Publish.LockedMode
+	if lockedMode != nil && !*lockedMode {
+		return nil, nil
+	}
+
+	lockFiles, err := f.Project.LockFilePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	if lockedMode != nil && len(lockFiles) == 0 {
+		return nil, errors.New("locked-mode is enabled in buildpack.yml, but the app has no packages.lock.json; set RestorePackagesWithLockFile to true in the project and commit the lock file")
+	}
+	return lockFiles, nil
+}
+
+// projectLockedMode reports whether restoring a project other than the main
+// one runs in locked mode: as dotnet-core.publish.locked-mode says when it is
+// set, and otherwise when the project has a lock file of its own
+func (f *Finalizer) projectLockedMode(projectPath string) (bool, error) {
+	if lockedMode := f.Config.Publish.LockedMode; lockedMode != nil {
+		return *lockedMode, nil
+	}
+
+	lockFile, err := f.Project.ProjectLockFilePath(projectPath)
+	return lockFile != "", err
+}
+
+// lockFileError turns the NU1004 errors restore reports in locked mode into an
+// error naming the projects whose lock files are out of date
+func (f *Finalizer) lockFileError(output string) error {
+	matches := nu1004RE.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	found := map[string]bool{}
+	var details []string
+	for _, match := range matches {
+		project := match[1]
+		if rel, err := filepath.Rel(f.Stager.BuildDir(), project); err == nil && !strings.HasPrefix(rel, "..") {
+			project = rel
+		}
+		if found[project] {
+			continue
+		}
+		found[project] = true
+		details = append(details, fmt.Sprintf("  %s: %s", project, match[2]))
+	}
+
+	return fmt.Errorf("the NuGet lock file is out of date for %d project(s):\n%s\nRun 'dotnet restore --force-evaluate' and commit the updated packages.lock.json files, or set dotnet-core.publish.locked-mode to false in buildpack.yml", len(details), strings.Join(details, "\n"))
+}
@@ -0,0 +1,80 @@
+package finalize
+
+import "fmt"
+
+// memoryScript tunes the .NET runtime for the container's MEMORY_LIMIT when
+// the app starts, since the runtime's cgroup detection does not always see
+// the limit Cloud Foundry enforces:
+//
+//   - the GC heap is limited to 75% of MEMORY_LIMIT, which leaves the rest for
+//     thread stacks, JIT-compiled code and other native memory
+//   - Cloud Foundry gives apps CPU in proportion to their memory, so the app
+//     is counted as having one CPU per 1024M, at least one and no more than
+//     nproc or 32
+//   - server GC, when the app's runtimeconfig.json turns it on, is kept with
+//     one heap per CPU for apps with two or more CPUs, and replaced with
+//     workstation GC below that, where server GC heaps would not fit. The
+//     heaps are not affinitized to CPUs, since every container on the cell
+//     would pin its heaps to the same first CPUs
+//   - the thread pool starts with four worker threads per CPU
+//
+// Every setting is left alone when the app's environment sets it with the
+// DOTNET_ or COMPlus_ prefix, and nothing is set without a MEMORY_LIMIT.
+const memoryScript = `
+dotnet_is_set() {
+  eval "[ -n \"\${DOTNET_$1+set}\" ] || [ -n \"\${COMPlus_$1+set}\" ]"
+}
+
+dotnet_set_default() {
+  dotnet_is_set "$1" || export "DOTNET_$1=$2"
+}
+
+dotnet_memory_value="${MEMORY_LIMIT%[mMgG]}"
+case "$dotnet_memory_value" in
+  ''|*[!0-9]*) dotnet_memory_mb=0 ;;
+  *)
+    case "${MEMORY_LIMIT#"$dotnet_memory_value"}" in
+      [gG]) dotnet_memory_mb=$(( dotnet_memory_value * 1024 )) ;;
+      [mM]) dotnet_memory_mb=$dotnet_memory_value ;;
+      *) dotnet_memory_mb=0 ;;
+    esac
+    ;;
+esac
+
+if [ "$dotnet_memory_mb" -gt 0 ]; then
+  if ! dotnet_is_set GCHeapHardLimitPercent; then
+    dotnet_set_default GCHeapHardLimit "$(printf '0x%x' $(( dotnet_memory_mb * 1024 * 1024 * 75 / 100 )))"
+  fi
+
+  dotnet_cpus=$(( dotnet_memory_mb / 1024 ))
+  dotnet_nproc=$(nproc 2>/dev/null || echo 1)
+  [ "$dotnet_cpus" -gt "$dotnet_nproc" ] && dotnet_cpus=$dotnet_nproc
+  [ "$dotnet_cpus" -gt 32 ] && dotnet_cpus=32
+  [ "$dotnet_cpus" -lt 1 ] && dotnet_cpus=1
+
+  if [ "$dotnet_server_gc" = true ] && [ "$dotnet_cpus" -ge 2 ]; then
+    dotnet_set_default gcServer 1
+    dotnet_set_default GCHeapCount "$(printf '0x%x' "$dotnet_cpus")"
+    dotnet_set_default GCNoAffinitize 1
+  elif [ "$dotnet_server_gc" = true ]; then
+    dotnet_set_default gcServer 0
+  fi
+
+  dotnet_set_default ThreadPool_ForceMinWorkerThreads "$(printf '0x%x' $(( dotnet_cpus * 4 )))"
+fi
+
+unset dotnet_server_gc dotnet_memory_value dotnet_memory_mb dotnet_cpus dotnet_nproc
+unset -f dotnet_is_set dotnet_set_default
+`
+
+// writeMemoryProfileD writes the profile.d script that tunes the runtime for
+// the container's memory limit
+func (f *Finalizer) writeMemoryProfileD() error {
+	serverGC, err := f.Project.ServerGC()
+	if err != nil {
+		return err
+	}
+
+	f.Log.Debug("Tuning the .NET runtime for MEMORY_LIMIT at launch (server GC: %t)", serverGC)
+	return f.Stager.WriteProfileD("dotnet-memory.sh", fmt.Sprintf("dotnet_server_gc=%t\n", serverGC)+memoryScript)
+}
@@ -0,0 +1,163 @@
+package finalize
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func (f *Finalizer) migrationsBundlePath() string {
+	return filepath.Join(f.Stager.DepDir(), "migrations", "efbundle")
+}
+
+// migrationsCommand returns the release command that runs the migrations
+// bundle from the published app, so that it reads the app's appsettings.json
+func (f *Finalizer) migrationsCommand() string {
+	depDir := filepath.Join("${DEPS_DIR}", f.Stager.DepsIdx())
+	return fmt.Sprintf("cd %s && exec %s", filepath.Join(depDir, "dotnet_publish"), filepath.Join(depDir, "migrations", "efbundle"))
+}
+
+// BuildMigrationsBundle builds a self-contained Entity Framework migrations
+// bundle for the app's runtime identifier when it is enabled in buildpack.yml,
+// so that migrations can be run with cf run-task once the SDK is gone. It
+// uses the dotnet-ef local tool when the app has one, and otherwise installs
+// the version of dotnet-ef that matches Microsoft.EntityFrameworkCore.Design.
+// The bundle restores like dotnet publish, from the same package sources and
+// in locked mode when the app has lock files.
+func (f *Finalizer) BuildMigrationsBundle(stackRID string) error {
+	migrations := f.Config.Migrations
+	if !migrations.Enabled {
+		return nil
+	}
+
+	f.Log.BeginStep("Building Entity Framework migrations bundle")
+
+	mainProject, err := f.Project.MainPath()
+	if err != nil {
+		return err
+	}
+
+	migrationsProject := mainProject
+	if migrations.Project != "" {
+		migrationsProject = filepath.Join(f.Stager.BuildDir(), migrations.Project)
+	}
+
+	lockFiles, err := f.lockedModeLockFiles()
+	if err != nil {
+		return err
+	}
+
+	vendoredPackages, err := f.vendoredPackagesDir()
+	if err != nil {
+		return err
+	}
+
+	dotnetEF, err := f.dotnetEF(vendoredPackages)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.migrationsBundlePath()), 0755); err != nil {
+		return err
+	}
+
+	args := []string{
+		"migrations", "bundle",
+		"--self-contained",
+		"-r", f.runtimeIdentifier(stackRID),
+		"-o", f.migrationsBundlePath(),
+		"--project", migrationsProject,
+		"--startup-project", mainProject,
+		"--configuration", f.publicConfig(),
+		"--force",
+	}
+	if f.Config.TargetFramework != "" {
+		args = append(args, "--framework", f.Config.TargetFramework)
+	}
+	if migrations.Context != "" {
+		args = append(args, "--context", migrations.Context)
+	}
+
+	// dotnet ef takes no MSBuild arguments, but the builds it runs read the
+	// restore properties of dotnet publish from the environment
+	output := &bytes.Buffer{}
+	cmd := exec.Command(dotnetEF, args...)
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Env = append(f.shellEnvironment(), restoreProperties(len(lockFiles) > 0, vendoredPackages)...)
+	cmd.Stdout = io.MultiWriter(indentWriter(os.Stdout), output)
+	cmd.Stderr = indentWriter(os.Stderr)
+
+	f.Log.Debug("Running command: %v", cmd)
+	if err := f.Command.Run(cmd); err != nil {
+		if lockFileErr := f.lockFileError(output.String()); lockFileErr != nil {
+			return lockFileErr
+		}
+		return fmt.Errorf("dotnet ef migrations bundle failed: %v", err)
+	}
+
+	f.Log.Info("Run the migrations with 'cf run-task <app> --process %s'", migrations.Process)
+	return nil
+}
+
+// dotnetEF returns the dotnet-ef command restored by RestoreLocalTools, or
+// installs it into the local tools directory when the app has none, from the
+// vendored packages only when the app has them
+func (f *Finalizer) dotnetEF(vendoredPackages string) (string, error) {
+	path := filepath.Join(f.localToolsBinDir(), "dotnet-ef")
+	if exists, err := libbuildpack.FileExists(path); err != nil || exists {
+		return path, err
+	}
+
+	args := []string{"tool", "install", "dotnet-ef", "--tool-path", f.localToolsBinDir()}
+	if version, err := f.efDesignVersion(); err != nil {
+		return "", err
+	} else if version != "" {
+		args = append(args, "--version", version)
+	}
+	if vendoredPackages != "" {
+		configPath, cleanup, err := vendoredNuGetConfig(vendoredPackages)
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+		args = append(args, "--configfile", configPath)
+	}
+
+	cmd := exec.Command("dotnet", args...)
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Env = f.shellEnvironment()
+	cmd.Stdout = indentWriter(os.Stdout)
+	cmd.Stderr = indentWriter(os.Stderr)
+
+	f.Log.Debug("Running command: %v", cmd)
+	if err := f.Command.Run(cmd); err != nil {
+		return "", fmt.Errorf("could not install dotnet-ef: %v", err)
+	}
+	return path, nil
+}
+
+// efDesignVersion returns the version of Microsoft.EntityFrameworkCore.Design
+// the app references, or "" when it is not a plain version
+func (f *Finalizer) efDesignVersion() (string, error) {
+	references, err := f.Project.PackageReferences()
+	if err != nil {
+		return "", err
+	}
+
+	for _, ref := range references {
+		if !strings.EqualFold(ref.Include, "Microsoft.EntityFrameworkCore.Design") {
+			continue
+		}
+		if _, err := semver.Parse(ref.Version); err == nil {
+			return ref.Version, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,247 @@
+package finalize
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var nugetSourceKeyRE = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+type nugetFeed struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type nugetConfig struct {
+	XMLName           xml.Name           `xml:"configuration"`
+	ClearSources      *struct{}          `xml:"packageSources>clear"`
+	PackageSources    []nugetSetting     `xml:"packageSources>add"`
+	SourceCredentials []nugetCredentials `xml:"packageSourceCredentials>source"`
+}
+
+type nugetCredentials struct {
+	XMLName  xml.Name
+	Settings []nugetSetting `xml:"add"`
+}
+
+type nugetSetting struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// nugetConfigPath returns the user-wide NuGet.Config that dotnet reads with
+// the HOME set by shellEnvironment
+func (f *Finalizer) nugetConfigPath() string {
+	return filepath.Join(f.Stager.DepDir(), ".nuget", "NuGet", "NuGet.Config")
+}
+
+// ConfigureNuGetFeeds writes a NuGet.Config with the private package feeds
+// bound to the app, so that source-based apps can restore packages from them
+// without committing credentials. Feeds come from user-provided services
+// tagged nuget in VCAP_SERVICES, with url, username, password and optionally
+// name credentials or a feeds list of them, and from NUGET_FEED_URL,
+// NUGET_FEED_USERNAME and NUGET_FEED_PASSWORD or NUGET_FEED_<NAME>_URL and
+// friends for several feeds. A feed without a name is named after its
+// service, followed by its position for the entries of a feeds list.
+func (f *Finalizer) ConfigureNuGetFeeds() error {
+	feeds, err := nugetFeedsFromServices()
+	if err != nil {
+		return err
+	}
+	feeds = append(feeds, nugetFeedsFromEnvironment()...)
+
+	if len(feeds) == 0 {
+		return nil
+	}
+
+	if dir, err := f.vendoredPackagesDir(); err != nil {
+		return err
+	} else if dir != "" {
+		f.Log.Info("Ignoring NuGet feeds, the app restores from %s only", vendoredPackagesDirName)
+		return nil
+	}
+
+	config := nugetConfig{
+		PackageSources: []nugetSetting{{Key: "nuget.org", Value: "https://api.nuget.org/v3/index.json"}},
+	}
+
+	var names []string
+	keys := map[string]bool{}
+	for _, feed := range feeds {
+		if feed.URL == "" {
+			return fmt.Errorf("NuGet feed %s has no url", feed.Name)
+		}
+
+		key := nugetSourceKeyRE.ReplaceAllString(feed.Name, "_")
+		if key == "" || !isLetter(key[0]) {
+			key = "feed_" + key
+		}
+		// NuGet compares source keys without case and rejects duplicates
+		if keys[strings.ToLower(key)] {
+			return fmt.Errorf("more than one NuGet feed is named %s, give each feed a different name", key)
+		}
+		keys[strings.ToLower(key)] = true
+
+		config.PackageSources = append(config.PackageSources, nugetSetting{Key: key, Value: feed.URL})
+		if feed.Username != "" || feed.Password != "" {
+			config.SourceCredentials = append(config.SourceCredentials, nugetCredentials{
+				XMLName: xml.Name{Local: key},
+				Settings: []nugetSetting{
+					{Key: "Username", Value: feed.Username},
+					{Key: "ClearTextPassword", Value: feed.Password},
+					{Key: "ValidAuthenticationTypes", Value: "basic"},
+				},
+			})
+		}
+		names = append(names, key)
+	}
+
+	content, err := xml.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.nugetConfigPath()), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.nugetConfigPath(), append([]byte(xml.Header), content...), 0600); err != nil {
+		return err
+	}
+
+	f.Log.Info("Using NuGet feeds: %s", strings.Join(names, ", "))
+	return nil
+}
+
+// vendoredNuGetConfig writes a NuGet.Config whose only package source is the
+// vendored packages folder to a temporary directory, for the dotnet commands
+// that have no option to replace the package sources of the app's
+// NuGet.Config files. It returns the path of the file and a function that
+// removes it.
+func vendoredNuGetConfig(vendoredPackages string) (string, func(), error) {
+	config := nugetConfig{
+		ClearSources:   &struct{}{},
+		PackageSources: []nugetSetting{{Key: vendoredPackagesDirName, Value: vendoredPackages}},
+	}
+
+	content, err := xml.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "nuget-config")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, "NuGet.Config")
+	if err := os.WriteFile(path, append([]byte(xml.Header), content...), 0644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// removeNuGetConfig removes the NuGet.Config written by ConfigureNuGetFeeds,
+// which holds feed credentials that must not end up in the droplet
+func (f *Finalizer) removeNuGetConfig() error {
+	if err := os.Remove(f.nugetConfigPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func nugetFeedsFromServices() ([]nugetFeed, error) {
+	data := os.Getenv("VCAP_SERVICES")
+	if data == "" {
+		return nil, nil
+	}
+
+	var services map[string][]struct {
+		Name        string   `json:"name"`
+		Tags        []string `json:"tags"`
+		Credentials struct {
+			nugetFeed
+			Feeds []nugetFeed `json:"feeds"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal([]byte(data), &services); err != nil {
+		return nil, fmt.Errorf("unable to parse VCAP_SERVICES: %v", err)
+	}
+
+	var feeds []nugetFeed
+	for _, service := range services["user-provided"] {
+		if !hasTag(service.Tags, "nuget") {
+			continue
+		}
+
+		credentials := service.Credentials
+		if credentials.URL != "" {
+			feed := credentials.nugetFeed
+			if feed.Name == "" {
+				feed.Name = service.Name
+			}
+			feeds = append(feeds, feed)
+		}
+
+		for i, feed := range credentials.Feeds {
+			if feed.Name == "" {
+				feed.Name = fmt.Sprintf("%s_%d", service.Name, i+1)
+			}
+			feeds = append(feeds, feed)
+		}
+	}
+	return feeds, nil
+}
+
+func nugetFeedsFromEnvironment() []nugetFeed {
+	var feeds []nugetFeed
+	if url := os.Getenv("NUGET_FEED_URL"); url != "" {
+		feeds = append(feeds, nugetFeed{
+			Name:     "nuget-feed",
+			URL:      url,
+			Username: os.Getenv("NUGET_FEED_USERNAME"),
+			Password: os.Getenv("NUGET_FEED_PASSWORD"),
+		})
+	}
+
+	var prefixes []string
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, "NUGET_FEED_") && strings.HasSuffix(name, "_URL") && name != "NUGET_FEED_URL" {
+			prefixes = append(prefixes, strings.TrimSuffix(name, "URL"))
+		}
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		feeds = append(feeds, nugetFeed{
+			Name:     strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(prefix, "NUGET_FEED_"), "_")),
+			URL:      os.Getenv(prefix + "URL"),
+			Username: os.Getenv(prefix + "USERNAME"),
+			Password: os.Getenv(prefix + "PASSWORD"),
+		})
+	}
+	return feeds
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
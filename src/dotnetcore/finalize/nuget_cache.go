@@ -0,0 +1,221 @@
+package finalize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const defaultNuGetCacheMaxSizeMB = 1024
+
+// nugetCacheInputs are the files restore and tool restore read to decide
+// which packages an app needs, so their contents key the package cache
+var nugetCacheInputs = map[string]bool{
+	"packages.lock.json":       true,
+	"directory.packages.props": true,
+	"directory.build.props":    true,
+	"directory.build.targets":  true,
+	"nuget.config":             true,
+	"global.json":              true,
+	"dotnet-tools.json":        true,
+}
+
+func (f *Finalizer) nugetPackagesDir() string {
+	return filepath.Join(f.Stager.DepDir(), ".nuget", "packages")
+}
+
+func (f *Finalizer) nugetCacheDir() string {
+	return filepath.Join(f.Stager.CacheDir(), "nuget")
+}
+
+// RestoreNuGetPackages restores the NuGet packages saved by SaveNuGetPackages
+// during the previous staging, so that dotnet publish only downloads the
+// packages that changed. The cache is only used when the project files, lock
+// files and NuGet configuration are the same as when it was saved, and it is
+// discarded when CLEAR_NUGET_CACHE is true. The cache key is computed here,
+// before dotnet publish writes to the app directory, and SaveNuGetPackages
+// saves the packages under the same key.
+func (f *Finalizer) RestoreNuGetPackages() error {
+	key, err := f.nugetCacheKey()
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv("CLEAR_NUGET_CACHE") == "true" {
+		f.Log.Info("Clearing NuGet package cache")
+		return os.RemoveAll(f.nugetCacheDir())
+	}
+
+	if exists, err := libbuildpack.FileExists(filepath.Join(f.nugetCacheDir(), "packages")); err != nil || !exists {
+		return err
+	}
+
+	cachedKey, err := os.ReadFile(filepath.Join(f.nugetCacheDir(), "key"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if string(cachedKey) != key {
+		f.Log.Info("NuGet package cache is out of date, restoring all packages")
+		return os.RemoveAll(f.nugetCacheDir())
+	}
+
+	// The cache is copied rather than moved, so that it is still there for
+	// the next staging when this one fails before SaveNuGetPackages
+	f.Log.Info("Restoring NuGet packages from cache")
+	if err := os.MkdirAll(f.nugetPackagesDir(), 0755); err != nil {
+		return err
+	}
+	return libbuildpack.CopyDirectory(filepath.Join(f.nugetCacheDir(), "packages"), f.nugetPackagesDir())
+}
+
+// SaveNuGetPackages saves the packages restored by dotnet publish in the app
+// cache, unless they are larger than NUGET_CACHE_MAX_SIZE_MB. The cache only
+// speeds up the next staging, so failing to save it is only a warning.
+func (f *Finalizer) SaveNuGetPackages() error {
+	if err := f.saveNuGetPackages(); err != nil {
+		f.Log.Warning("Unable to save NuGet packages to cache: %s", err.Error())
+	}
+	return nil
+}
+
+func (f *Finalizer) saveNuGetPackages() error {
+	if exists, err := libbuildpack.FileExists(f.nugetPackagesDir()); err != nil || !exists {
+		return err
+	}
+
+	maxSize := int64(defaultNuGetCacheMaxSizeMB)
+	if value := os.Getenv("NUGET_CACHE_MAX_SIZE_MB"); value != "" {
+		var err error
+		if maxSize, err = strconv.ParseInt(value, 10, 64); err != nil || maxSize < 0 {
+			return fmt.Errorf("invalid value '%s' for NUGET_CACHE_MAX_SIZE_MB", value)
+		}
+	}
+
+	if err := os.RemoveAll(f.nugetCacheDir()); err != nil {
+		return err
+	}
+
+	size, err := directorySize(f.nugetPackagesDir())
+	if err != nil {
+		return err
+	}
+
+	sizeMB := size / 1024 / 1024
+	if sizeMB > maxSize {
+		f.Log.Info("NuGet packages (%d MB) exceed the cache size limit of %d MB, not caching them", sizeMB, maxSize)
+		return nil
+	}
+
+	key, err := f.nugetCacheKey()
+	if err != nil {
+		return err
+	}
+
+	f.Log.Info("Saving NuGet packages to cache (%d MB)", sizeMB)
+	if err := moveDirectory(f.nugetPackagesDir(), filepath.Join(f.nugetCacheDir(), "packages")); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(f.nugetCacheDir(), "key"), []byte(key), 0644)
+}
+
+// nugetCacheKey hashes the files that determine the packages an app restores,
+// along with the stack, architecture and publish settings. The key is computed
+// once per staging, since restore and publish write files such as
+// obj/project.assets.json that are not there when the next staging starts.
+func (f *Finalizer) nugetCacheKey() (string, error) {
+	if f.nugetKey != "" {
+		return f.nugetKey, nil
+	}
+
+	var paths []string
+	err := filepath.Walk(f.Stager.BuildDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			switch info.Name() {
+			case ".cloudfoundry", "node_modules", ".git", "obj", "bin":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := strings.ToLower(info.Name())
+		if nugetCacheInputs[name] || strings.HasSuffix(name, ".csproj") || strings.HasSuffix(name, ".fsproj") || strings.HasSuffix(name, ".vbproj") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n", os.Getenv("CF_STACK"), platform.Arch(), f.Config.TargetFramework, f.Config.Publish.RuntimeIdentifier)
+	for _, path := range paths {
+		rel, err := filepath.Rel(f.Stager.BuildDir(), path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\n", rel)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	f.nugetKey = hex.EncodeToString(hash.Sum(nil))
+	return f.nugetKey, nil
+}
+
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// moveDirectory moves src to dest, copying it when they are on different
+// file systems
+func moveDirectory(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	if err := libbuildpack.CopyDirectory(src, dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
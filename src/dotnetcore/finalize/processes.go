@@ -0,0 +1,151 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
+)
+
+var procfileProjectRE = regexp.MustCompile(`^[^\s]+\.[a-z]+proj$`)
+
+// process is an extra process type that runs another project of the app
+type process struct {
+	name     string
+	project  string
+	procfile bool
+}
+
+// processes returns the extra process types of a source-based app, which are
+// the ones in the processes section of buildpack.yml and the Procfile entries
+// that name a project file instead of a command
+func (f *Finalizer) processes() ([]process, error) {
+	var processes []process
+	for name, projectPath := range f.Config.Processes {
+		projectPath, err := f.Project.ProcessProjectPath(name, projectPath)
+		if err != nil {
+			return nil, err
+		}
+		processes = append(processes, process{name: name, project: filepath.Join(f.Stager.BuildDir(), projectPath)})
+	}
+
+	entries, err := f.procfileEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !procfileProjectRE.MatchString(entry[1]) {
+			continue
+		}
+
+		name := entry[0]
+		if err := project.CheckProcessName(name); err != nil {
+			return nil, fmt.Errorf("Procfile: %w", err)
+		}
+		if _, ok := f.Config.Processes[name]; ok {
+			return nil, fmt.Errorf("process %s is defined in both the Procfile and buildpack.yml", name)
+		}
+
+		projectPath, err := f.Project.ProcessProjectPath(name, entry[1])
+		if err != nil {
+			return nil, err
+		}
+		processes = append(processes, process{name: name, project: filepath.Join(f.Stager.BuildDir(), projectPath), procfile: true})
+	}
+
+	sort.Slice(processes, func(i, j int) bool { return processes[i].name < processes[j].name })
+	return processes, nil
+}
+
+// processCommands returns the release command of each extra process type,
+// which starts its published project
+func (f *Finalizer) processCommands(processes []process) (map[string]string, error) {
+	commands := map[string]string{}
+	for _, proc := range processes {
+		startCmd, err := f.Project.ProcessStartCommand(proc.name, proc.project)
+		if err != nil {
+			return nil, err
+		} else if startCmd == "" {
+			return nil, fmt.Errorf("could not find the published project of process %s", proc.name)
+		}
+		commands[proc.name] = processCommand(startCmd)
+	}
+	return commands, nil
+}
+
+// procfileEntries returns the process types and commands of the app's
+// Procfile
+func (f *Finalizer) procfileEntries() ([][2]string, error) {
+	data, err := os.ReadFile(filepath.Join(f.Stager.BuildDir(), "Procfile"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries [][2]string
+	for _, line := range strings.Split(string(data), "\n") {
+		name, command, found := strings.Cut(line, ":")
+		if !found || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		entries = append(entries, [2]string{strings.TrimSpace(name), strings.TrimSpace(command)})
+	}
+	return entries, nil
+}
+
+// RewriteProcfile replaces the project files named by Procfile entries with
+// the commands that start the published projects, because Cloud Foundry runs
+// the Procfile commands in place of the buildpack's default process types
+func (f *Finalizer) RewriteProcfile() error {
+	processes, err := f.processes()
+	if err != nil {
+		return err
+	}
+
+	var procfileProcesses []process
+	for _, proc := range processes {
+		if proc.procfile {
+			procfileProcesses = append(procfileProcesses, proc)
+		}
+	}
+	if len(procfileProcesses) == 0 {
+		return nil
+	}
+
+	commands, err := f.processCommands(procfileProcesses)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.Stager.BuildDir(), "Procfile")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		name, _, found := strings.Cut(line, ":")
+		if command, ok := commands[strings.TrimSpace(name)]; found && ok {
+			lines[i] = fmt.Sprintf("%s: %s", strings.TrimSpace(name), command)
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// processCommand returns the release command that runs startCmd from the
+// directory it was published to
+func processCommand(startCmd string) string {
+	directory := filepath.Dir(startCmd)
+	startCmd = "./" + filepath.Base(startCmd)
+	if strings.HasSuffix(startCmd, ".dll") {
+		startCmd = "dotnet " + startCmd
+	}
+	return fmt.Sprintf("cd %s && exec %s", directory, startCmd)
+}
@@ -0,0 +1,52 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// The scripts apps can provide in .dotnet-buildpack to run their own build
+// steps around dotnet publish
+const (
+	PrePublishHook  = "pre-publish"
+	PostPublishHook = "post-publish"
+)
+
+const publishHooksDir = ".dotnet-buildpack"
+
+// RunPublishHook runs the app's .dotnet-buildpack/<name> script, when it has
+// one, from the app directory and with the environment dotnet publish runs
+// with. DOTNET_PUBLISH_DIR points the script at the published output.
+// Staging fails when the script exits with an error.
+func (f *Finalizer) RunPublishHook(name string) error {
+	path := filepath.Join(f.Stager.BuildDir(), publishHooksDir, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	relPath := filepath.Join(publishHooksDir, name)
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", relPath)
+	} else if info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable, make it executable with 'chmod +x %s' and push again", relPath, relPath)
+	}
+
+	f.Log.BeginStep("Running %s", relPath)
+
+	cmd := exec.Command(path)
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Env = append(f.shellEnvironment(), "DOTNET_PUBLISH_DIR="+filepath.Join(f.Stager.DepDir(), "dotnet_publish"))
+	cmd.Stdout = indentWriter(os.Stdout)
+	cmd.Stderr = indentWriter(os.Stderr)
+
+	f.Log.Debug("Running command: %v", cmd)
+	if err := f.Command.Run(cmd); err != nil {
+		return fmt.Errorf("%s failed: %v", relPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckStartCommand checks that the start assembly and working directory set
+// in buildpack.yml exist in the published app, so that a typo fails staging
+// instead of every start of the app
+func (f *Finalizer) CheckStartCommand() error {
+	start := f.Config.Start
+	if start.Assembly == "" && start.WorkingDir == "" {
+		return nil
+	}
+
+	publishedDir := filepath.Join(f.Stager.DepDir(), "dotnet_publish")
+	if published, err := f.Project.IsPublished(); err != nil {
+		return err
+	} else if published {
+		publishedDir = f.Stager.BuildDir()
+	}
+
+	// The start command falls back to any single-file bundle of the app, so
+	// the start assembly itself has to be there
+	if start.Assembly != "" {
+		if found, err := startAssemblyExists(publishedDir, start.Assembly); err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("start assembly %s not found in the published app, it has neither %s nor %s.dll", start.Assembly, start.Assembly, start.Assembly)
+		}
+	}
+
+	startCmd, err := f.Project.StartCommand()
+	if err != nil {
+		return err
+	}
+
+	if start.WorkingDir != "" {
+		if info, err := os.Stat(filepath.Join(publishedDir, start.WorkingDir)); os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+			return fmt.Errorf("start working-dir %s is not a directory in the published app", start.WorkingDir)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if startCmd != "" {
+		f.Log.Info("Starting the app with: %s", f.webCommand(startCmd))
+	}
+	return nil
+}
+
+// startAssemblyExists reports whether dir has the executable or the .dll of
+// the assembly name
+func startAssemblyExists(dir, name string) (bool, error) {
+	for _, path := range []string{filepath.Join(dir, name), filepath.Join(dir, name+".dll")} {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return true, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// webCommand returns the release command of the web process, which starts
+// startCmd from its directory, or the working directory set in buildpack.yml,
+// with the arguments set there
+func (f *Finalizer) webCommand(startCmd string) string {
+	start := f.Config.Start
+
+	command := processCommand(startCmd)
+	if start.WorkingDir != "" {
+		exe := startCmd
+		if strings.HasSuffix(exe, ".dll") {
+			exe = "dotnet " + exe
+		}
+		command = fmt.Sprintf("cd %s && exec %s", filepath.Join(filepath.Dir(startCmd), start.WorkingDir), exe)
+	}
+
+	if start.Args != "" {
+		command += " " + start.Args
+	}
+	return command
+}
@@ -0,0 +1,156 @@
+package finalize
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type trxTestRun struct {
+	Counters struct {
+		Total       int `xml:"total,attr"`
+		Passed      int `xml:"passed,attr"`
+		Failed      int `xml:"failed,attr"`
+		NotExecuted int `xml:"notExecuted,attr"`
+	} `xml:"ResultSummary>Counters"`
+	Results []struct {
+		TestName string `xml:"testName,attr"`
+		Outcome  string `xml:"outcome,attr"`
+		Message  string `xml:"Output>ErrorInfo>Message"`
+	} `xml:"Results>UnitTestResult"`
+}
+
+// RunTests runs dotnet test on the app's test projects when the test gate is
+// enabled in buildpack.yml, and fails staging when any of the tests fail. The
+// tests restore from the same package sources as dotnet publish, in locked
+// mode when the test project has a lock file. The TRX results of each project are summarized in the staging log.
+func (f *Finalizer) RunTests() error {
+	if !f.Config.Test.Enabled {
+		return nil
+	}
+
+	f.Log.BeginStep("Running tests")
+
+	testProjects, err := f.Project.TestProjectPaths()
+	if err != nil {
+		return err
+	}
+	if len(testProjects) == 0 {
+		f.Log.Warning("Tests are enabled in buildpack.yml, but the app has no test projects")
+		return nil
+	}
+
+	vendoredPackages, err := f.vendoredPackagesDir()
+	if err != nil {
+		return err
+	}
+
+	resultsDir, err := os.MkdirTemp("", "dotnet-test-results")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(resultsDir)
+
+	var failures []string
+	for i, testProject := range testProjects {
+		name, err := filepath.Rel(f.Stager.BuildDir(), testProject)
+		if err != nil {
+			return err
+		}
+
+		projectResultsDir := filepath.Join(resultsDir, strconv.Itoa(i))
+		args := []string{"test", testProject, "-c", f.publicConfig(), "--logger", "trx", "--results-directory", projectResultsDir}
+		lockedMode, err := f.projectLockedMode(testProject)
+		if err != nil {
+			return err
+		}
+		args = append(args, restoreArgs(lockedMode, vendoredPackages)...)
+		if f.Config.Test.Filter != "" {
+			args = append(args, "--filter", f.Config.Test.Filter)
+		}
+
+		cmd := exec.Command("dotnet", args...)
+		cmd.Dir = f.Stager.BuildDir()
+		cmd.Env = f.shellEnvironment()
+		output := &bytes.Buffer{}
+		cmd.Stdout = io.MultiWriter(indentWriter(os.Stdout), output)
+		cmd.Stderr = indentWriter(os.Stderr)
+
+		f.Log.Debug("Running command: %v", cmd)
+		runErr := f.Command.Run(cmd)
+		if runErr != nil {
+			if lockFileErr := f.lockFileError(output.String()); lockFileErr != nil {
+				return lockFileErr
+			}
+		}
+
+		failed, err := f.summarizeTestResults(name, projectResultsDir)
+		if err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			failures = append(failures, fmt.Sprintf("%s (%d failed)", name, failed))
+		} else if runErr != nil {
+			failures = append(failures, fmt.Sprintf("%s (%v)", name, runErr))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("tests failed in %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// summarizeTestResults logs the counts and the failed tests of the TRX files
+// dotnet test wrote for a project, and returns the number of failed tests
+func (f *Finalizer) summarizeTestResults(name, resultsDir string) (int, error) {
+	trxFiles, err := filepath.Glob(filepath.Join(resultsDir, "*.trx"))
+	if err != nil {
+		return 0, err
+	}
+
+	var total, passed, failed, skipped int
+	var failedTests []string
+	for _, trxFile := range trxFiles {
+		data, err := os.ReadFile(trxFile)
+		if err != nil {
+			return 0, err
+		}
+
+		var run trxTestRun
+		if err := xml.Unmarshal(data, &run); err != nil {
+			return 0, fmt.Errorf("could not parse test results %s: %v", filepath.Base(trxFile), err)
+		}
+
+		total += run.Counters.Total
+		passed += run.Counters.Passed
+		failed += run.Counters.Failed
+		skipped += run.Counters.NotExecuted
+
+		for _, result := range run.Results {
+			if result.Outcome != "Failed" {
+				continue
+			}
+			message, _, _ := strings.Cut(strings.TrimSpace(result.Message), "\n")
+			failedTests = append(failedTests, fmt.Sprintf("%s: %s", result.TestName, strings.TrimSpace(message)))
+		}
+	}
+
+	if len(trxFiles) == 0 {
+		f.Log.Info("%s: no test results", name)
+		return 0, nil
+	}
+
+	f.Log.Info("%s: %d total, %d passed, %d failed, %d skipped", name, total, passed, failed, skipped)
+	for _, test := range failedTests {
+		f.Log.Info("  FAILED %s", test)
+	}
+	return failed, nil
+}
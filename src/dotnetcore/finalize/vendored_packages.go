@@ -0,0 +1,256 @@
+package finalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// vendoredPackagesDirName is the folder of .nupkg files an app can push with
+// it to stage without access to any NuGet feed
+const vendoredPackagesDirName = ".nuget-packages"
+
+// nupkgRE splits a .nupkg file name into the package id and version
+var nupkgRE = regexp.MustCompile(`^(.+?)\.(\d+(?:\.\d+){0,3}(?:-[0-9A-Za-z.-]+)?)\.nupkg$`)
+
+type lockFile struct {
+	Dependencies map[string]map[string]struct {
+		Type     string `json:"type"`
+		Resolved string `json:"resolved"`
+	} `json:"dependencies"`
+}
+
+// vendoredPackagesDir returns the app's vendored package folder, or "" when
+// it has none
+func (f *Finalizer) vendoredPackagesDir() (string, error) {
+	dir := filepath.Join(f.Stager.BuildDir(), vendoredPackagesDirName)
+	if exists, err := libbuildpack.FileExists(dir); err != nil || !exists {
+		return "", err
+	}
+	return dir, nil
+}
+
+// CheckVendoredPackages makes sure that an app with a .nuget-packages folder
+// vendors every package it needs, since dotnet publish restores from that
+// folder only. The packages come from the app's NuGet lock files when it has
+// them, and otherwise from the PackageReferences of its projects, along with
+// the runtime, Crossgen2, ILLink and ILCompiler packs the SDK restores for the
+// publish mode. Every missing package is reported at once rather than one
+// restore failure at a time.
+func (f *Finalizer) CheckVendoredPackages(stackRID string) error {
+	dir, err := f.vendoredPackagesDir()
+	if err != nil || dir == "" {
+		return err
+	}
+
+	vendored, err := vendoredPackages(dir)
+	if err != nil {
+		return err
+	}
+	f.Log.Info("Restoring NuGet packages from %s only (%d packages)", vendoredPackagesDirName, len(vendored))
+
+	lockFiles, err := f.Project.LockFilePaths()
+	if err != nil {
+		return err
+	}
+
+	// Lock files do not always list the packs, so they are checked either way
+	found := map[string]bool{}
+	missing, err := f.missingImplicitPacks(vendored, stackRID, found)
+	if err != nil {
+		return err
+	}
+
+	var missingPackages []string
+	if len(lockFiles) > 0 {
+		missingPackages, err = f.missingLockedPackages(lockFiles, vendored, f.runtimeIdentifier(stackRID), found)
+	} else {
+		missingPackages, err = f.missingReferencedPackages(vendored, found)
+	}
+	if err != nil {
+		return err
+	}
+
+	missing = append(missing, missingPackages...)
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+
+	return fmt.Errorf("%s is missing %d NuGet package(s) the app needs:\n  %s\nAdd the .nupkg files of these packages to %s, for example from the NuGet global packages folder after running 'dotnet restore'", vendoredPackagesDirName, len(missing), strings.Join(missing, "\n  "), vendoredPackagesDirName)
+}
+
+func (f *Finalizer) missingLockedPackages(lockFiles []string, vendored map[string][]string, runtimeIdentifier string, found map[string]bool) ([]string, error) {
+	var missing []string
+	for _, path := range lockFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var lock lockFile
+		if err := json.Unmarshal(data, &lock); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", filepath.Base(path), err)
+		}
+
+		for target, dependencies := range lock.Dependencies {
+			framework, rid, _ := strings.Cut(target, "/")
+			if f.Config.TargetFramework != "" && !strings.EqualFold(framework, f.Config.TargetFramework) {
+				continue
+			}
+			if rid != "" && rid != runtimeIdentifier {
+				continue
+			}
+
+			for id, dependency := range dependencies {
+				if strings.EqualFold(dependency.Type, "Project") {
+					continue
+				}
+
+				name := id + " " + dependency.Resolved
+				if found[strings.ToLower(name)] {
+					continue
+				}
+				found[strings.ToLower(name)] = true
+
+				if !hasVersion(vendored[strings.ToLower(id)], normalizeNuGetVersion(dependency.Resolved)) {
+					missing = append(missing, name)
+				}
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// missingImplicitPacks returns the runtime, Crossgen2, ILLink and ILCompiler
+// packs the SDK restores for the publish mode that are not vendored
+func (f *Finalizer) missingImplicitPacks(vendored map[string][]string, stackRID string, found map[string]bool) ([]string, error) {
+	packs, err := f.Project.ImplicitPacks(project.PackOptions{
+		TargetFramework:       f.Config.TargetFramework,
+		RuntimeIdentifier:     f.runtimeIdentifier(stackRID),
+		HostRuntimeIdentifier: stackRID,
+		SelfContained:         !f.Config.FrameworkDependent,
+		ReadyToRun:            f.Config.Publish.ReadyToRun,
+		Trimmed:               f.Config.Publish.Trimmed,
+		NativeAot:             f.Config.NativeAot,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, pack := range packs {
+		name := pack.Name + " " + pack.Version
+		if found[strings.ToLower(name)] {
+			continue
+		}
+		found[strings.ToLower(name)] = true
+
+		if !hasVersion(vendored[strings.ToLower(pack.Name)], normalizeNuGetVersion(pack.Version)) {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+func (f *Finalizer) missingReferencedPackages(vendored map[string][]string, found map[string]bool) ([]string, error) {
+	references, err := f.Project.PackageReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, ref := range references {
+		name := strings.TrimSpace(ref.Include + " " + ref.Version)
+		if ref.Include == "" || found[strings.ToLower(name)] {
+			continue
+		}
+		found[strings.ToLower(name)] = true
+
+		versions := vendored[strings.ToLower(ref.Include)]
+		if len(versions) == 0 {
+			missing = append(missing, name)
+			continue
+		}
+
+		// Restore picks the lowest version at or above a plain version, so
+		// only those need one that high; ranges and floating versions are
+		// satisfied by any vendored version
+		minimum, err := semver.ParseTolerant(ref.Version)
+		if err != nil {
+			continue
+		}
+		if !anyVersion(versions, func(v semver.Version) bool { return v.GTE(minimum) }) {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing, nil
+}
+
+// vendoredPackages returns the normalized versions of the packages in dir by
+// lower case package id. Packages can be at the top of the folder or in the
+// <id>/<version> layout of a NuGet packages folder.
+func vendoredPackages(dir string) (map[string][]string, error) {
+	packages := map[string][]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		if matches := nupkgRE.FindStringSubmatch(strings.ToLower(info.Name())); len(matches) == 3 {
+			packages[matches[1]] = append(packages[matches[1]], normalizeNuGetVersion(matches[2]))
+		}
+		return nil
+	})
+	return packages, err
+}
+
+// normalizeNuGetVersion returns version the way NuGet names package files,
+// with at least three parts, a fourth one only when it is not zero and no
+// build metadata
+func normalizeNuGetVersion(version string) string {
+	version, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(version)), "+")
+	release, prerelease, hasPrerelease := strings.Cut(version, "-")
+
+	parts := strings.Split(release, ".")
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	if len(parts) == 4 && parts[3] == "0" {
+		parts = parts[:3]
+	}
+
+	version = strings.Join(parts, ".")
+	if hasPrerelease {
+		version += "-" + prerelease
+	}
+	return version
+}
+
+func hasVersion(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func anyVersion(versions []string, match func(semver.Version) bool) bool {
+	for _, v := range versions {
+		if version, err := semver.ParseTolerant(v); err == nil && match(version) {
+			return true
+		}
+	}
+	return false
+}
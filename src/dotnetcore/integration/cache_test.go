@@ -53,5 +53,18 @@ func testCache(platform switchblade.Platform, fixtures string) func(*testing.T,
 			Expect(logs).NotTo(ContainLines(MatchRegexp(DownloadRegexp)))
 			Expect(logs).To(ContainLines(MatchRegexp(CopyRegexp)))
 		})
+
+		it("restores NuGet packages from the cache when deployed twice", func() {
+			_, logs, err := platform.Deploy.Execute(name, source)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logs).To(ContainLines(ContainSubstring("Saving NuGet packages to cache")))
+			Expect(logs).NotTo(ContainLines(ContainSubstring("Restoring NuGet packages from cache")))
+
+			_, logs, err = platform.Deploy.Execute(name, source)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logs).To(ContainLines(ContainSubstring("Restoring NuGet packages from cache")))
+		})
 	}
 }
@@ -0,0 +1,113 @@
+package platform
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+var supportedStacks = map[string]bool{
+	"cflinuxfs3": true,
+	"cflinuxfs4": true,
+	"cflinuxfs5": true,
+}
+
+// goArchToArch maps Go architecture names to the ones used by .NET runtime
+// identifiers and by the file names of the dependencies in manifest.yml
+var goArchToArch = map[string]string{
+	"amd64": "x64",
+	"arm64": "arm64",
+}
+
+// machineToArch maps the machine names printed by uname -m to the
+// architecture names of goArchToArch
+var machineToArch = map[string]string{
+	"x86_64":  "x64",
+	"amd64":   "x64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+}
+
+var dependencyArchRE = regexp.MustCompile(`_linux_([a-z0-9]+)_`)
+
+var (
+	archOnce sync.Once
+	arch     string
+)
+
+// Arch returns the CPU architecture of the cell the app is staged on, as
+// reported by uname -m. The buildpack binaries may have been built for
+// another architecture than the cell's, so runtime.GOARCH is only used when
+// uname cannot be run.
+func Arch() string {
+	archOnce.Do(func() {
+		if output, err := exec.Command("uname", "-m").Output(); err == nil {
+			arch = MachineArch(strings.TrimSpace(string(output)))
+			return
+		}
+
+		arch = runtime.GOARCH
+		if goArch, ok := goArchToArch[runtime.GOARCH]; ok {
+			arch = goArch
+		}
+	})
+	return arch
+}
+
+// MachineArch returns the architecture name of a machine name printed by
+// uname -m, or the machine name itself when it is not a known architecture
+func MachineArch(machine string) string {
+	if arch, ok := machineToArch[machine]; ok {
+		return arch
+	}
+	return machine
+}
+
+// RuntimeIdentifier returns the .NET runtime identifier that apps are
+// published for on a stack and architecture
+func RuntimeIdentifier(stack, arch string) (string, error) {
+	if !supportedStacks[stack] {
+		return "", fmt.Errorf("unsupported stack: %s", stack)
+	}
+
+	for _, supported := range goArchToArch {
+		if arch == supported {
+			return "linux-" + arch, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported architecture %s on stack %s", arch, stack)
+}
+
+// DependencyArch returns the architecture a manifest dependency was built for,
+// taken from its file name. Dependencies that do not name one predate
+// multi-architecture support and were built for x64.
+func DependencyArch(entry libbuildpack.ManifestEntry) string {
+	name := path.Base(entry.URI)
+	if entry.File != "" {
+		name = path.Base(entry.File)
+	}
+
+	if matches := dependencyArchRE.FindStringSubmatch(name); len(matches) == 2 {
+		return matches[1]
+	}
+	return "x64"
+}
+
+// FilterManifest removes the dependencies built for other architectures from
+// the manifest, so that versions are only resolved among the ones that run on
+// the cell
+func FilterManifest(manifest *libbuildpack.Manifest, arch string) {
+	var entries []libbuildpack.ManifestEntry
+	for _, entry := range manifest.ManifestEntries {
+		if depArch := DependencyArch(entry); depArch == arch || depArch == "noarch" {
+			entries = append(entries, entry)
+		}
+	}
+	manifest.ManifestEntries = entries
+}
@@ -0,0 +1,81 @@
+package platform_test
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Platform", func() {
+	Describe("Arch", func() {
+		It("returns the architecture of the machine", func() {
+			output, err := exec.Command("uname", "-m").Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform.Arch()).To(Equal(platform.MachineArch(strings.TrimSpace(string(output)))))
+		})
+	})
+
+	Describe("MachineArch", func() {
+		It("maps machine names to architectures", func() {
+			Expect(platform.MachineArch("x86_64")).To(Equal("x64"))
+			Expect(platform.MachineArch("aarch64")).To(Equal("arm64"))
+			Expect(platform.MachineArch("s390x")).To(Equal("s390x"))
+		})
+	})
+
+	Describe("RuntimeIdentifier", func() {
+		It("returns the RID for x64 and arm64", func() {
+			Expect(platform.RuntimeIdentifier("cflinuxfs4", "x64")).To(Equal("linux-x64"))
+			Expect(platform.RuntimeIdentifier("cflinuxfs4", "arm64")).To(Equal("linux-arm64"))
+		})
+
+		It("returns an error for an unsupported stack", func() {
+			_, err := platform.RuntimeIdentifier("windows", "x64")
+			Expect(err).To(MatchError("unsupported stack: windows"))
+		})
+
+		It("returns an error for an unsupported architecture", func() {
+			_, err := platform.RuntimeIdentifier("cflinuxfs4", "s390x")
+			Expect(err).To(MatchError("unsupported architecture s390x on stack cflinuxfs4"))
+		})
+	})
+
+	Describe("FilterManifest", func() {
+		var manifest *libbuildpack.Manifest
+
+		entry := func(name, uri string) libbuildpack.ManifestEntry {
+			return libbuildpack.ManifestEntry{Dependency: libbuildpack.Dependency{Name: name, Version: "8.0.4"}, URI: uri}
+		}
+
+		BeforeEach(func() {
+			manifest = &libbuildpack.Manifest{ManifestEntries: []libbuildpack.ManifestEntry{
+				entry("dotnet-runtime", "https://example.com/dotnet-runtime_8.0.4_linux_x64_cflinuxfs4_abcdef12.tar.xz"),
+				entry("dotnet-runtime", "https://example.com/dotnet-runtime_8.0.4_linux_arm64_cflinuxfs4_abcdef12.tar.xz"),
+				entry("bower", "https://example.com/bower_1.8.14_linux_noarch_any-stack_00df3dcc.tgz"),
+				entry("libunwind", "https://example.com/libunwind-1.6.2.tgz"),
+			}}
+		})
+
+		It("keeps the arm64 and architecture independent dependencies on arm64", func() {
+			platform.FilterManifest(manifest, "arm64")
+			Expect(manifest.ManifestEntries).To(Equal([]libbuildpack.ManifestEntry{
+				entry("dotnet-runtime", "https://example.com/dotnet-runtime_8.0.4_linux_arm64_cflinuxfs4_abcdef12.tar.xz"),
+				entry("bower", "https://example.com/bower_1.8.14_linux_noarch_any-stack_00df3dcc.tgz"),
+			}))
+		})
+
+		It("treats dependencies without an architecture as x64", func() {
+			platform.FilterManifest(manifest, "x64")
+			Expect(manifest.ManifestEntries).To(Equal([]libbuildpack.ManifestEntry{
+				entry("dotnet-runtime", "https://example.com/dotnet-runtime_8.0.4_linux_x64_cflinuxfs4_abcdef12.tar.xz"),
+				entry("bower", "https://example.com/bower_1.8.14_linux_noarch_any-stack_00df3dcc.tgz"),
+				entry("libunwind", "https://example.com/libunwind-1.6.2.tgz"),
+			}))
+		})
+	})
+})
@@ -0,0 +1,206 @@
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	upgradeAssistantHint = "Migrate the project to an SDK-style project targeting .NET 8 or later, for example with the .NET Upgrade Assistant: https://learn.microsoft.com/dotnet/core/porting/upgrade-assistant-overview"
+	windowsStackHint     = "Windows-only apps can be pushed to a Windows stack with the hwc_buildpack or binary_buildpack instead."
+)
+
+var (
+	netFrameworkTFMRE = regexp.MustCompile(`^net\d{2,3}$`)
+	xamarinTFMRE      = regexp.MustCompile(`^(monoandroid|xamarinios|xamarinmac|xamarintvos|xamarinwatchos)`)
+	platformTFMRE     = regexp.MustCompile(`^net\d+\.\d+-([a-z]+)`)
+)
+
+// IncompatibleProjectError explains why an app cannot run on Cloud Foundry's
+// Linux stacks and how it can be migrated
+type IncompatibleProjectError struct {
+	Project string
+	Reason  string
+	Hint    string
+}
+
+func (e *IncompatibleProjectError) Error() string {
+	return fmt.Sprintf("%s %s, which is not supported on Linux.\n%s", e.Project, e.Reason, e.Hint)
+}
+
+// CheckCompatibility stops staging, before anything is downloaded, for apps
+// that are built for Windows desktop, the .NET Framework, Xamarin, MAUI or UWP
+// rather than for .NET on Linux. These would otherwise fail deep inside
+// dotnet publish or when the app starts.
+func (p *Project) CheckCompatibility() error {
+	path, err := p.RuntimeConfigPath()
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		runtimeConfig, err := parseRuntimeConfig(path)
+		if err != nil {
+			return err
+		}
+
+		for _, fw := range append([]Framework{runtimeConfig.RuntimeOptions.Framework}, runtimeConfig.RuntimeOptions.Frameworks...) {
+			if err := checkSharedFramework(fw.Name, filepath.Base(path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	mainPath, err := p.MainPath()
+	if err != nil || mainPath == "" {
+		return err
+	}
+
+	proj, err := p.parseProj()
+	if err != nil {
+		return err
+	}
+
+	return proj.checkCompatibility(filepath.Base(mainPath))
+}
+
+// checkSharedFramework returns an error for a shared framework in a
+// runtimeconfig.json that the buildpack cannot install
+func checkSharedFramework(name, runtimeConfig string) error {
+	switch name {
+	case "", "Microsoft.NETCore.App", "Microsoft.AspNetCore.App":
+		return nil
+	case "Microsoft.WindowsDesktop.App":
+		return &IncompatibleProjectError{
+			Project: runtimeConfig,
+			Reason:  "requires the Microsoft.WindowsDesktop.App framework for WPF and Windows Forms",
+			Hint:    "Move the app's logic into a console, worker or ASP.NET Core project. " + windowsStackHint,
+		}
+	}
+	return &IncompatibleProjectError{
+		Project: runtimeConfig,
+		Reason:  fmt.Sprintf("requires the shared framework %s", name),
+		Hint:    "This buildpack only provides the Microsoft.NETCore.App and Microsoft.AspNetCore.App frameworks. Publish the app self-contained to include other frameworks.",
+	}
+}
+
+func (proj CSProj) checkCompatibility(name string) error {
+	frameworks := proj.targetFrameworks()
+	props := proj.PropertyGroup
+
+	if (proj.ToolsVersion != "" && proj.Sdk == "") || strings.HasPrefix(strings.ToLower(props.TargetFrameworkVersion), "v") {
+		reason := "is an old-style project for the .NET Framework"
+		if props.TargetFrameworkVersion != "" {
+			reason = fmt.Sprintf("targets the .NET Framework %s", props.TargetFrameworkVersion)
+		}
+		return &IncompatibleProjectError{Project: name, Reason: reason, Hint: upgradeAssistantHint}
+	}
+
+	if strings.EqualFold(props.TargetPlatformIdentifier, "UAP") || anyFramework(frameworks, func(fw string) bool { return strings.HasPrefix(fw, "uap") }) {
+		return &IncompatibleProjectError{
+			Project: name,
+			Reason:  "is a Universal Windows Platform (UWP) app",
+			Hint:    "Move the app's logic into an ASP.NET Core or worker project targeting .NET 8 or later. " + windowsStackHint,
+		}
+	}
+
+	mauiError := &IncompatibleProjectError{
+		Project: name,
+		Reason:  "is a Xamarin or .NET MAUI app for mobile and desktop devices",
+		Hint:    "Only server apps can be pushed to Cloud Foundry. Put the app's backend in a separate ASP.NET Core project and push that one.",
+	}
+	if strings.EqualFold(props.UseMaui, "true") || proj.hasPackageReference("Xamarin.Forms") {
+		return mauiError
+	}
+
+	if strings.EqualFold(props.UseWPF, "true") || strings.EqualFold(props.UseWindowsForms, "true") || proj.usesSdk("Microsoft.NET.Sdk.WindowsDesktop") {
+		return &IncompatibleProjectError{
+			Project: name,
+			Reason:  "is a WPF or Windows Forms app",
+			Hint:    "Move the app's logic into a console, worker or ASP.NET Core project. " + windowsStackHint,
+		}
+	}
+
+	if len(frameworks) > 0 && !anyFramework(frameworks, isLinuxFramework) {
+		if anyFramework(frameworks, func(fw string) bool { return netFrameworkTFMRE.MatchString(fw) }) {
+			return &IncompatibleProjectError{
+				Project: name,
+				Reason:  fmt.Sprintf("targets the .NET Framework (%s)", strings.Join(frameworks, ";")),
+				Hint:    upgradeAssistantHint,
+			}
+		}
+
+		if anyFramework(frameworks, isMobileFramework) {
+			return mauiError
+		}
+
+		return &IncompatibleProjectError{
+			Project: name,
+			Reason:  fmt.Sprintf("only targets Windows (%s)", strings.Join(frameworks, ";")),
+			Hint:    "Add a target framework without the -windows suffix, such as net8.0, to the project. " + windowsStackHint,
+		}
+	}
+
+	return nil
+}
+
+func (proj CSProj) hasPackageReference(name string) bool {
+	for _, ig := range proj.ItemGroups {
+		for _, ref := range ig.PackageReferences {
+			if strings.EqualFold(ref.Include, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (proj CSProj) usesSdk(name string) bool {
+	for _, sdk := range proj.sdks() {
+		if strings.EqualFold(sdk, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLinuxFramework reports whether a target framework moniker can be built
+// and run on Linux
+func isLinuxFramework(targetFramework string) bool {
+	tfm := strings.ToLower(targetFramework)
+	if netFrameworkTFMRE.MatchString(tfm) || strings.HasPrefix(tfm, "uap") || xamarinTFMRE.MatchString(tfm) {
+		return false
+	}
+
+	if matches := platformTFMRE.FindStringSubmatch(tfm); len(matches) == 2 {
+		return matches[1] == "linux"
+	}
+	return true
+}
+
+func isMobileFramework(targetFramework string) bool {
+	tfm := strings.ToLower(targetFramework)
+	if xamarinTFMRE.MatchString(tfm) {
+		return true
+	}
+
+	if matches := platformTFMRE.FindStringSubmatch(tfm); len(matches) == 2 {
+		switch matches[1] {
+		case "android", "ios", "maccatalyst", "macos", "tvos", "tizen":
+			return true
+		}
+	}
+	return false
+}
+
+func anyFramework(frameworks []string, match func(string) bool) bool {
+	for _, fw := range frameworks {
+		if match(strings.ToLower(fw)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package project
+
+import "path/filepath"
+
+// ServerGC reports whether the published app's runtimeconfig.json turns on
+// server garbage collection with System.GC.Server, which the Web SDK does by
+// default. The runtimeconfig.json is the one of the assembly that starts the
+// app, and apps whose runtimeconfig.json cannot be told apart from the others
+// in their output, or that have none, such as Native AOT apps, use
+// workstation garbage collection unless their environment says otherwise.
+func (p *Project) ServerGC() (bool, error) {
+	dir := filepath.Join(p.depDir, "dotnet_publish")
+	if published, err := p.IsPublished(); err != nil {
+		return false, err
+	} else if published {
+		dir = p.buildDir
+	}
+
+	entryAssembly, err := p.mainAssemblyName()
+	if err != nil {
+		p.Log.Debug("Could not find the assembly that starts the app, using workstation GC: %s", err.Error())
+		return false, nil
+	}
+
+	path, err := runtimeConfigPath(dir, entryAssembly)
+	if err != nil {
+		p.Log.Debug("Could not find the runtimeconfig.json of %s, using workstation GC: %s", entryAssembly, err.Error())
+		return false, nil
+	} else if path == "" {
+		return false, nil
+	}
+
+	runtimeConfig, err := parseRuntimeConfig(path)
+	if err != nil {
+		return false, err
+	}
+
+	return configPropertyTrue(runtimeConfig, "System.GC.Server"), nil
+}
@@ -0,0 +1,32 @@
+package project
+
+import "strings"
+
+// InvariantGlobalization reports whether the app runs in
+// globalization-invariant mode, and so does not need ICU: published apps turn
+// it on with System.Globalization.Invariant in their runtimeconfig.json, and
+// source-based apps with the InvariantGlobalization property of the main
+// project.
+func (p *Project) InvariantGlobalization() (bool, error) {
+	runtimeConfigPath, err := p.RuntimeConfigPath()
+	if err != nil {
+		return false, err
+	} else if runtimeConfigPath != "" {
+		runtimeConfig, err := parseRuntimeConfig(runtimeConfigPath)
+		if err != nil {
+			return false, err
+		}
+		return configPropertyTrue(runtimeConfig, "System.Globalization.Invariant"), nil
+	}
+
+	mainPath, err := p.MainPath()
+	if err != nil || !isProjectFile(mainPath) {
+		return false, err
+	}
+
+	proj, err := p.parseProj()
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(proj.PropertyGroup.InvariantGlobalization, "true"), nil
+}
@@ -0,0 +1,118 @@
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// LockFilePaths returns the NuGet lock files of the main project and of the
+// projects it references, directly or indirectly. A project's lock file is
+// packages.lock.json or packages.<project name>.lock.json next to it, unless
+// the project sets NuGetLockFilePath.
+func (p *Project) LockFilePaths() ([]string, error) {
+	var lockFiles []string
+	err := p.walkProjects(func(path string, proj CSProj) error {
+		lockFile, err := projectLockFile(path, proj)
+		if lockFile != "" {
+			lockFiles = append(lockFiles, lockFile)
+		}
+		return err
+	})
+	return lockFiles, err
+}
+
+// ProjectLockFilePath returns the NuGet lock file of the project at
+// projectPath alone, or "" when it has none
+func (p *Project) ProjectLockFilePath(projectPath string) (string, error) {
+	proj, err := loadProject(projectPath, p.buildDir, p.globalProperties)
+	if err != nil {
+		return "", fmt.Errorf("could not parse project file %s: %v", p.relativePath(projectPath), err)
+	}
+	return projectLockFile(projectPath, proj)
+}
+
+// PackageReferences returns the PackageReferences of the main project and of
+// the projects it references, directly or indirectly
+func (p *Project) PackageReferences() ([]Reference, error) {
+	var references []Reference
+	err := p.walkProjects(func(_ string, proj CSProj) error {
+		for _, ig := range proj.ItemGroups {
+			references = append(references, ig.PackageReferences...)
+		}
+		return nil
+	})
+	return references, err
+}
+
+// walkProjects calls visit for the main project and every project it
+// references, directly or indirectly, that exists
+func (p *Project) walkProjects(visit func(path string, proj CSProj) error) error {
+	mainPath, err := p.MainPath()
+	if err != nil || mainPath == "" || !isProjectFile(mainPath) {
+		return err
+	}
+
+	visited := map[string]bool{}
+	paths := []string{mainPath}
+	for len(paths) > 0 {
+		path := paths[0]
+		paths = paths[1:]
+		if visited[path] {
+			continue
+		}
+		visited[path] = true
+
+		if exists, err := libbuildpack.FileExists(path); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+
+		proj, err := loadProject(path, p.buildDir, p.globalProperties)
+		if err != nil {
+			return fmt.Errorf("could not parse project file %s: %v", p.relativePath(path), err)
+		}
+
+		if err := visit(path, proj); err != nil {
+			return err
+		}
+
+		for _, ig := range proj.ItemGroups {
+			for _, ref := range ig.ProjectReferences {
+				paths = append(paths, ref.Include)
+			}
+		}
+	}
+	return nil
+}
+
+func projectLockFile(projectPath string, proj CSProj) (string, error) {
+	dir := filepath.Dir(projectPath)
+
+	var candidates []string
+	if lockFilePath := proj.PropertyGroup.NuGetLockFilePath; lockFilePath != "" {
+		lockFilePath = strings.ReplaceAll(lockFilePath, `\`, "/")
+		if !filepath.IsAbs(lockFilePath) {
+			lockFilePath = filepath.Join(dir, lockFilePath)
+		}
+		candidates = append(candidates, lockFilePath)
+	} else {
+		name := strings.TrimSuffix(filepath.Base(projectPath), filepath.Ext(projectPath))
+		candidates = append(candidates,
+			filepath.Join(dir, fmt.Sprintf("packages.%s.lock.json", strings.ReplaceAll(name, " ", "_"))),
+			filepath.Join(dir, "packages.lock.json"),
+		)
+	}
+
+	for _, candidate := range candidates {
+		if exists, err := libbuildpack.FileExists(candidate); err != nil {
+			return "", err
+		} else if exists {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,549 @@
+package project
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var propertyReferenceRE = regexp.MustCompile(`\$\(\s*([A-Za-z_][A-Za-z0-9_-]*)\s*\)`)
+
+type propertyGroup struct {
+	Condition  string `xml:"Condition,attr"`
+	Properties []struct {
+		XMLName   xml.Name
+		Condition string `xml:"Condition,attr"`
+		Value     string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+type importElement struct {
+	Project   string `xml:"Project,attr"`
+	Sdk       string `xml:"Sdk,attr"`
+	Condition string `xml:"Condition,attr"`
+}
+
+// msbuildEvaluation collects the properties and items of a project file and
+// of the files it imports, in the order MSBuild evaluates them, so that later
+// definitions of a property override earlier ones. Property references are
+// expanded and Conditions evaluated as each element is read. Global
+// properties, like the Configuration passed to dotnet publish, cannot be
+// overridden by the project, and environment variables are used for
+// properties the project does not define.
+type msbuildEvaluation struct {
+	globalProperties map[string]string
+	properties       map[string]string
+	itemGroups       []ItemGroup
+	sdks             []string
+	toolsVersion     string
+	visited          map[string]bool
+	projectPath      string
+	currentFile      string
+}
+
+// loadProject evaluates the project file at projectPath together with the
+// Directory.Build.props and Directory.Build.targets files found in its
+// directory or any parent directory up to rootDir.
+func loadProject(projectPath, rootDir string, globalProperties map[string]string) (CSProj, error) {
+	e := &msbuildEvaluation{
+		globalProperties: map[string]string{},
+		properties:       map[string]string{},
+		visited:          map[string]bool{},
+		projectPath:      projectPath,
+	}
+	for name, value := range globalProperties {
+		e.globalProperties[strings.ToLower(name)] = value
+	}
+
+	if props := findDirectoryBuildFile("Directory.Build.props", filepath.Dir(projectPath), rootDir); props != "" {
+		if err := e.importFile(props); err != nil {
+			return CSProj{}, err
+		}
+	}
+
+	if err := e.importFile(projectPath); err != nil {
+		return CSProj{}, err
+	}
+
+	if targets := findDirectoryBuildFile("Directory.Build.targets", filepath.Dir(projectPath), rootDir); targets != "" {
+		if err := e.importFile(targets); err != nil {
+			return CSProj{}, err
+		}
+	}
+
+	return e.csproj(), nil
+}
+
+func (e *msbuildEvaluation) importFile(path string) error {
+	path = filepath.Clean(path)
+	if e.visited[path] {
+		return nil
+	}
+	e.visited[path] = true
+
+	projFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer projFile.Close()
+
+	parentFile := e.currentFile
+	e.currentFile = path
+	defer func() { e.currentFile = parentFile }()
+
+	decoder := xml.NewDecoder(projFile)
+	root, err := nextStartElement(decoder)
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range root.Attr {
+		if attr.Name.Local == "Sdk" && attr.Value != "" {
+			e.sdks = append(e.sdks, attr.Value)
+		}
+		if attr.Name.Local == "ToolsVersion" && path == filepath.Clean(e.projectPath) {
+			e.toolsVersion = attr.Value
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.EndElement:
+			if element.Name == root.Name {
+				return nil
+			}
+		case xml.StartElement:
+			if err := e.evaluateElement(decoder, element, filepath.Dir(path)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (e *msbuildEvaluation) evaluateElement(decoder *xml.Decoder, element xml.StartElement, dir string) error {
+	switch element.Name.Local {
+	case "PropertyGroup":
+		group := propertyGroup{}
+		if err := decoder.DecodeElement(&group, &element); err != nil {
+			return err
+		}
+		if !e.condition(group.Condition) {
+			return nil
+		}
+		for _, property := range group.Properties {
+			if e.condition(property.Condition) {
+				e.setProperty(property.XMLName.Local, e.expand(strings.TrimSpace(property.Value)))
+			}
+		}
+	case "ItemGroup":
+		group := ItemGroup{}
+		if err := decoder.DecodeElement(&group, &element); err != nil {
+			return err
+		}
+		if !e.condition(group.Condition) {
+			return nil
+		}
+		group.PackageReferences = e.references(group.PackageReferences)
+		group.FrameworkReferences = e.references(group.FrameworkReferences)
+		group.ProjectReferences = e.references(group.ProjectReferences)
+		for i, ref := range group.ProjectReferences {
+			group.ProjectReferences[i].Include = e.path(ref.Include, dir)
+		}
+		e.itemGroups = append(e.itemGroups, group)
+	case "Sdk":
+		for _, attr := range element.Attr {
+			if attr.Name.Local == "Name" && attr.Value != "" {
+				e.sdks = append(e.sdks, attr.Value)
+			}
+		}
+		return decoder.Skip()
+	case "Import":
+		imp := importElement{}
+		if err := decoder.DecodeElement(&imp, &element); err != nil {
+			return err
+		}
+		if !e.condition(imp.Condition) {
+			return nil
+		}
+		if imp.Sdk != "" {
+			e.sdks = append(e.sdks, imp.Sdk)
+		}
+		return e.importProject(imp, dir)
+	default:
+		return decoder.Skip()
+	}
+	return nil
+}
+
+// references returns the items whose Condition is true, with their
+// attributes expanded
+func (e *msbuildEvaluation) references(refs []Reference) []Reference {
+	var evaluated []Reference
+	for _, ref := range refs {
+		if e.condition(ref.Condition) {
+			ref.Include, ref.Version = e.expand(ref.Include), e.expand(ref.Version)
+			evaluated = append(evaluated, ref)
+		}
+	}
+	return evaluated
+}
+
+// importProject follows an <Import> element. Imports of MSBuild SDKs and of
+// paths that cannot be resolved on disk are ignored, as they only hold build
+// logic that ships with the SDK.
+func (e *msbuildEvaluation) importProject(imp importElement, dir string) error {
+	project := e.expand(imp.Project)
+	if imp.Sdk != "" || project == "" || strings.Contains(project, "$(") {
+		return nil
+	}
+
+	pattern := e.path(project, dir)
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil
+	}
+
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		if err := e.importFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msbuildEvaluation) property(name string) string {
+	key := strings.ToLower(name)
+	switch key {
+	case "msbuildthisfiledirectory":
+		return filepath.Dir(e.currentFile) + string(filepath.Separator)
+	case "msbuildthisfile":
+		return filepath.Base(e.currentFile)
+	case "msbuildthisfilename":
+		return strings.TrimSuffix(filepath.Base(e.currentFile), filepath.Ext(e.currentFile))
+	case "msbuildprojectdirectory":
+		return filepath.Dir(e.projectPath)
+	case "msbuildprojectfile":
+		return filepath.Base(e.projectPath)
+	case "msbuildprojectname":
+		return strings.TrimSuffix(filepath.Base(e.projectPath), filepath.Ext(e.projectPath))
+	}
+
+	if value, ok := e.globalProperties[key]; ok {
+		return value
+	}
+	if value, ok := e.properties[key]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func (e *msbuildEvaluation) setProperty(name, value string) {
+	key := strings.ToLower(name)
+	if _, ok := e.globalProperties[key]; ok {
+		return
+	}
+	e.properties[key] = value
+}
+
+// expand replaces $(Name) property references. Property functions, like
+// $([System.IO.Path]::Combine(...)), are left as they are.
+func (e *msbuildEvaluation) expand(value string) string {
+	return propertyReferenceRE.ReplaceAllStringFunc(value, func(reference string) string {
+		return e.property(propertyReferenceRE.FindStringSubmatch(reference)[1])
+	})
+}
+
+// path resolves a path from a project file, which may use Windows separators,
+// relative to dir
+func (e *msbuildEvaluation) path(value, dir string) string {
+	path := filepath.FromSlash(strings.ReplaceAll(value, `\`, "/"))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
+
+// condition evaluates a Condition attribute. An empty condition is true, and a
+// condition that cannot be evaluated is false.
+func (e *msbuildEvaluation) condition(condition string) bool {
+	if strings.TrimSpace(condition) == "" {
+		return true
+	}
+
+	tokens, err := tokenizeCondition(condition)
+	if err != nil {
+		return false
+	}
+
+	parser := &conditionParser{evaluation: e, tokens: tokens}
+	result, err := parser.or()
+	if err != nil || parser.pos != len(tokens) {
+		return false
+	}
+	return result
+}
+
+func (e *msbuildEvaluation) csproj() CSProj {
+	proj := CSProj{Sdk: strings.Join(e.sdks, ";"), ToolsVersion: e.toolsVersion, ItemGroups: e.itemGroups}
+	proj.PropertyGroup.TargetFramework = e.property("TargetFramework")
+	proj.PropertyGroup.TargetFrameworks = e.property("TargetFrameworks")
+	proj.PropertyGroup.RuntimeFrameworkVersion = e.property("RuntimeFrameworkVersion")
+	proj.PropertyGroup.AssemblyName = e.property("AssemblyName")
+	proj.PropertyGroup.OutputType = e.property("OutputType")
+	proj.PropertyGroup.IsTestProject = e.property("IsTestProject")
+	proj.PropertyGroup.TargetFrameworkVersion = e.property("TargetFrameworkVersion")
+	proj.PropertyGroup.TargetPlatformIdentifier = e.property("TargetPlatformIdentifier")
+	proj.PropertyGroup.UseWPF = e.property("UseWPF")
+	proj.PropertyGroup.UseWindowsForms = e.property("UseWindowsForms")
+	proj.PropertyGroup.UseMaui = e.property("UseMaui")
+	proj.PropertyGroup.NuGetLockFilePath = e.property("NuGetLockFilePath")
+	proj.PropertyGroup.PublishAot = e.property("PublishAot")
+	proj.PropertyGroup.InvariantGlobalization = e.property("InvariantGlobalization")
+	return proj
+}
+
+// findDirectoryBuildFile walks up from dir to rootDir and returns the first
+// file with the given name, the same way MSBuild locates Directory.Build.props
+func findDirectoryBuildFile(name, dir, rootDir string) string {
+	rootDir = filepath.Clean(rootDir)
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return filepath.Join(dir, name)
+		}
+
+		if dir == rootDir || !strings.HasPrefix(dir, rootDir) || dir == filepath.Dir(dir) {
+			return ""
+		}
+	}
+}
+
+func nextStartElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			return element, nil
+		}
+	}
+}
+
+type conditionToken struct {
+	quoted bool
+	value  string
+}
+
+func tokenizeCondition(condition string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for i := 0; i < len(condition); {
+		switch c := condition[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'':
+			end := strings.IndexByte(condition[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in condition %q", condition)
+			}
+			tokens = append(tokens, conditionToken{quoted: true, value: condition[i+1 : i+1+end]})
+			i += end + 2
+		case strings.HasPrefix(condition[i:], "=="), strings.HasPrefix(condition[i:], "!="),
+			strings.HasPrefix(condition[i:], "<="), strings.HasPrefix(condition[i:], ">="):
+			tokens = append(tokens, conditionToken{value: condition[i : i+2]})
+			i += 2
+		case strings.ContainsRune("()!<>,", rune(c)):
+			tokens = append(tokens, conditionToken{value: string(c)})
+			i++
+		case strings.HasPrefix(condition[i:], "$("):
+			end := strings.IndexByte(condition[i:], ')')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated property in condition %q", condition)
+			}
+			tokens = append(tokens, conditionToken{quoted: true, value: condition[i : i+end+1]})
+			i += end + 1
+		default:
+			end := i
+			for end < len(condition) && !strings.ContainsRune(" \t\r\n'()=!<>,", rune(condition[end])) {
+				end++
+			}
+			tokens = append(tokens, conditionToken{value: condition[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// conditionParser evaluates the parts of the MSBuild condition syntax that
+// project files commonly use: string comparisons, numeric comparisons,
+// Exists(), !, and, or and parentheses.
+type conditionParser struct {
+	evaluation *msbuildEvaluation
+	tokens     []conditionToken
+	pos        int
+}
+
+func (c *conditionParser) peek() (conditionToken, bool) {
+	if c.pos >= len(c.tokens) {
+		return conditionToken{}, false
+	}
+	return c.tokens[c.pos], true
+}
+
+func (c *conditionParser) keyword(value string) bool {
+	if token, ok := c.peek(); ok && !token.quoted && strings.EqualFold(token.value, value) {
+		c.pos++
+		return true
+	}
+	return false
+}
+
+func (c *conditionParser) or() (bool, error) {
+	left, err := c.and()
+	if err != nil {
+		return false, err
+	}
+	for c.keyword("or") {
+		right, err := c.and()
+		if err != nil {
+			return false, err
+		}
+		left = left || right
+	}
+	return left, nil
+}
+
+func (c *conditionParser) and() (bool, error) {
+	left, err := c.unary()
+	if err != nil {
+		return false, err
+	}
+	for c.keyword("and") {
+		right, err := c.unary()
+		if err != nil {
+			return false, err
+		}
+		left = left && right
+	}
+	return left, nil
+}
+
+func (c *conditionParser) unary() (bool, error) {
+	if c.keyword("!") {
+		value, err := c.unary()
+		return !value, err
+	}
+
+	if c.keyword("(") {
+		value, err := c.or()
+		if err != nil {
+			return false, err
+		}
+		if !c.keyword(")") {
+			return false, fmt.Errorf("expected ')'")
+		}
+		return value, nil
+	}
+
+	if c.keyword("Exists") {
+		if !c.keyword("(") {
+			return false, fmt.Errorf("expected '('")
+		}
+		path, err := c.operand()
+		if err != nil {
+			return false, err
+		}
+		if !c.keyword(")") {
+			return false, fmt.Errorf("expected ')'")
+		}
+		if strings.TrimSpace(path) == "" {
+			return false, nil
+		}
+		_, err = os.Stat(c.evaluation.path(strings.TrimSpace(path), filepath.Dir(c.evaluation.currentFile)))
+		return err == nil, nil
+	}
+
+	left, err := c.operand()
+	if err != nil {
+		return false, err
+	}
+
+	token, ok := c.peek()
+	if !ok || token.quoted {
+		return parseConditionBool(left)
+	}
+
+	switch op := token.value; op {
+	case "==", "!=", "<", ">", "<=", ">=":
+		c.pos++
+		right, err := c.operand()
+		if err != nil {
+			return false, err
+		}
+		return compareConditionOperands(left, op, right)
+	}
+	return parseConditionBool(left)
+}
+
+func (c *conditionParser) operand() (string, error) {
+	token, ok := c.peek()
+	if !ok {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+	if !token.quoted && strings.ContainsAny(token.value, "()!<>=,") {
+		return "", fmt.Errorf("unexpected %q in condition", token.value)
+	}
+	c.pos++
+	return c.evaluation.expand(token.value), nil
+}
+
+func compareConditionOperands(left, op, right string) (bool, error) {
+	switch op {
+	case "==":
+		return strings.EqualFold(left, right), nil
+	case "!=":
+		return !strings.EqualFold(left, right), nil
+	}
+
+	l, err := strconv.ParseFloat(strings.TrimSpace(left), 64)
+	if err != nil {
+		return false, err
+	}
+	r, err := strconv.ParseFloat(strings.TrimSpace(right), 64)
+	if err != nil {
+		return false, err
+	}
+
+	switch op {
+	case "<":
+		return l < r, nil
+	case ">":
+		return l > r, nil
+	case "<=":
+		return l <= r, nil
+	default:
+		return l >= r, nil
+	}
+}
+
+func parseConditionBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "on", "yes":
+		return true, nil
+	case "false", "off", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", value)
+}
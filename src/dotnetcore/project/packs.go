@@ -0,0 +1,167 @@
+package project
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// Pack is a NuGet package that dotnet publish restores without a
+// PackageReference, such as the runtime pack of a self-contained app
+type Pack struct {
+	Name    string
+	Version string
+}
+
+// PackOptions are the publish settings that decide which packs dotnet publish
+// restores
+type PackOptions struct {
+	TargetFramework       string
+	RuntimeIdentifier     string
+	HostRuntimeIdentifier string
+	SelfContained         bool
+	ReadyToRun            bool
+	Trimmed               bool
+	NativeAot             bool
+}
+
+// bundledVersions holds the known packs of Microsoft.NETCoreSdk.BundledVersions.props,
+// which the SDK resolves the versions of implicitly restored packs from
+type bundledVersions struct {
+	ItemGroups []struct {
+		FrameworkReferences []struct {
+			Include                       string `xml:"Include,attr"`
+			TargetFramework               string `xml:"TargetFramework,attr"`
+			Profile                       string `xml:"Profile,attr"`
+			LatestRuntimeFrameworkVersion string `xml:"LatestRuntimeFrameworkVersion,attr"`
+			RuntimePackNamePatterns       string `xml:"RuntimePackNamePatterns,attr"`
+		} `xml:"KnownFrameworkReference"`
+		Crossgen2Packs []struct {
+			TargetFramework          string `xml:"TargetFramework,attr"`
+			Crossgen2PackNamePattern string `xml:"Crossgen2PackNamePattern,attr"`
+			Crossgen2PackVersion     string `xml:"Crossgen2PackVersion,attr"`
+		} `xml:"KnownCrossgen2Pack"`
+		ILCompilerPacks []struct {
+			Include                   string `xml:"Include,attr"`
+			TargetFramework           string `xml:"TargetFramework,attr"`
+			ILCompilerPackNamePattern string `xml:"ILCompilerPackNamePattern,attr"`
+			ILCompilerPackVersion     string `xml:"ILCompilerPackVersion,attr"`
+		} `xml:"KnownILCompilerPack"`
+		ILLinkPacks []struct {
+			Include           string `xml:"Include,attr"`
+			TargetFramework   string `xml:"TargetFramework,attr"`
+			ILLinkPackVersion string `xml:"ILLinkPackVersion,attr"`
+		} `xml:"KnownILLinkPack"`
+	} `xml:"ItemGroup"`
+}
+
+// ImplicitPacks returns the packs dotnet publish restores for the main project
+// besides its PackageReferences: the runtime packs of a self-contained app,
+// the Crossgen2 pack of ReadyToRun, the ILLink pack of trimming and the
+// ILCompiler packs of Native AOT. Their versions come from the installed SDK,
+// so it returns none before the SDK is installed.
+func (p *Project) ImplicitPacks(options PackOptions) ([]Pack, error) {
+	versions, err := p.bundledVersions()
+	if err != nil || versions == nil {
+		return nil, err
+	}
+
+	proj, err := p.parseProj()
+	if err != nil {
+		return nil, err
+	}
+
+	targetFramework := options.TargetFramework
+	if targetFramework == "" {
+		if targetFramework, err = p.SourceTargetFramework(""); err != nil {
+			return nil, err
+		}
+	}
+
+	frameworks := map[string]bool{"Microsoft.NETCore.App": true}
+	if usesAspNetCore, err := p.usesAspNetCore(proj, map[string]bool{}); err != nil {
+		return nil, err
+	} else if usesAspNetCore {
+		frameworks["Microsoft.AspNetCore.App"] = true
+	}
+
+	var packs []Pack
+	for _, group := range versions.ItemGroups {
+		if options.SelfContained && options.RuntimeIdentifier != "" {
+			for _, fw := range group.FrameworkReferences {
+				if !frameworks[fw.Include] || fw.Profile != "" || !strings.EqualFold(fw.TargetFramework, targetFramework) || fw.RuntimePackNamePatterns == "" {
+					continue
+				}
+
+				version := fw.LatestRuntimeFrameworkVersion
+				if fw.Include == "Microsoft.NETCore.App" && proj.PropertyGroup.RuntimeFrameworkVersion != "" {
+					version = proj.PropertyGroup.RuntimeFrameworkVersion
+				}
+				for _, pattern := range strings.Split(fw.RuntimePackNamePatterns, ";") {
+					packs = append(packs, Pack{Name: strings.ReplaceAll(pattern, "**RID**", options.RuntimeIdentifier), Version: version})
+				}
+			}
+		}
+
+		if options.ReadyToRun && !options.NativeAot {
+			for _, pack := range group.Crossgen2Packs {
+				if strings.EqualFold(pack.TargetFramework, targetFramework) {
+					packs = append(packs, Pack{Name: strings.ReplaceAll(pack.Crossgen2PackNamePattern, "**RID**", options.HostRuntimeIdentifier), Version: pack.Crossgen2PackVersion})
+				}
+			}
+		}
+
+		if options.Trimmed || options.NativeAot {
+			for _, pack := range group.ILLinkPacks {
+				if strings.EqualFold(pack.TargetFramework, targetFramework) {
+					packs = append(packs, Pack{Name: pack.Include, Version: pack.ILLinkPackVersion})
+				}
+			}
+		}
+
+		if options.NativeAot {
+			for _, pack := range group.ILCompilerPacks {
+				if strings.EqualFold(pack.TargetFramework, targetFramework) {
+					packs = append(packs,
+						Pack{Name: pack.Include, Version: pack.ILCompilerPackVersion},
+						Pack{Name: strings.ReplaceAll(pack.ILCompilerPackNamePattern, "**RID**", options.HostRuntimeIdentifier), Version: pack.ILCompilerPackVersion},
+					)
+				}
+			}
+		}
+	}
+	return packs, nil
+}
+
+// bundledVersions parses the Microsoft.NETCoreSdk.BundledVersions.props of the
+// newest installed SDK, or returns nil when no SDK is installed
+func (p *Project) bundledVersions() (*bundledVersions, error) {
+	paths, err := filepath.Glob(filepath.Join(p.depDir, "dotnet-sdk", "sdk", "*", "Microsoft.NETCoreSdk.BundledVersions.props"))
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		vi, erri := semver.ParseTolerant(filepath.Base(filepath.Dir(paths[i])))
+		vj, errj := semver.ParseTolerant(filepath.Base(filepath.Dir(paths[j])))
+		if erri != nil || errj != nil {
+			return paths[i] < paths[j]
+		}
+		return vi.LT(vj)
+	})
+
+	data, err := os.ReadFile(paths[len(paths)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	versions := &bundledVersions{}
+	if err := xml.Unmarshal(data, versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,103 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+var processNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CheckProcessName checks that name is a valid process type for a project
+// other than the main one, which cannot be web
+func CheckProcessName(name string) error {
+	if !processNameRE.MatchString(name) {
+		return fmt.Errorf("invalid process type '%s'", name)
+	} else if name == "web" {
+		return errors.New("the web process runs the app's main project and cannot run another project")
+	}
+	return nil
+}
+
+// ProcessPublishDir returns the directory the project of an extra process
+// type is published to
+func (p *Project) ProcessPublishDir(name string) string {
+	return filepath.Join(p.depDir, "processes", name)
+}
+
+// ProcessProjectPath checks that projectPath, the project of the extra process
+// type name, is a project file inside the app, and returns it cleaned
+func (p *Project) ProcessProjectPath(name, projectPath string) (string, error) {
+	projectPath = filepath.Clean(projectPath)
+	if filepath.IsAbs(projectPath) || strings.HasPrefix(projectPath, "..") || !projFileRE.MatchString(projectPath) {
+		return "", fmt.Errorf("process %s must name a project file in the app, such as src/Worker/Worker.csproj", name)
+	}
+
+	if exists, err := libbuildpack.FileExists(filepath.Join(p.buildDir, projectPath)); err != nil {
+		return "", err
+	} else if !exists {
+		return "", fmt.Errorf("project %s of process %s not found", projectPath, name)
+	}
+	return projectPath, nil
+}
+
+// ProcessStartCommand returns the command that starts the project at
+// projectPath once it has been published to ProcessPublishDir(name)
+func (p *Project) ProcessStartCommand(name, projectPath string) (string, error) {
+	proj, err := p.loadProcessProject(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	return startCommandIn(p.ProcessPublishDir(name), filepath.Join("${DEPS_DIR}", p.depsIdx, "processes", name), proj.assemblyName(projectPath))
+}
+
+// ProcessTargetFramework returns the target framework to publish the project
+// of an extra process type for: targetFramework when the project is
+// multi-targeted and targets it, and "" to use the project's only target
+// framework otherwise
+func (p *Project) ProcessTargetFramework(projectPath, targetFramework string) (string, error) {
+	proj, err := p.loadProcessProject(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	frameworks := proj.targetFrameworks()
+	if len(frameworks) <= 1 || targetFramework == "" {
+		return "", nil
+	}
+
+	for _, fw := range frameworks {
+		if strings.EqualFold(fw, targetFramework) {
+			return fw, nil
+		}
+	}
+	return "", fmt.Errorf("%s does not target %s, which the app is published for", p.relativePath(projectPath), targetFramework)
+}
+
+// InstallProcessFrameworks installs the shared frameworks required by the
+// project of an extra process type that was published framework-dependent
+func (p *Project) InstallProcessFrameworks(name string) error {
+	return p.installFrameworks(p.ProcessPublishDir(name), "")
+}
+
+// loadProcessProject evaluates the project of an extra process type, without
+// the TargetFramework selected for the main project
+func (p *Project) loadProcessProject(projectPath string) (CSProj, error) {
+	globalProperties := map[string]string{}
+	for name, value := range p.globalProperties {
+		if name != "TargetFramework" {
+			globalProperties[name] = value
+		}
+	}
+
+	proj, err := loadProject(projectPath, p.buildDir, globalProperties)
+	if err != nil {
+		return CSProj{}, fmt.Errorf("could not parse project file %s: %v", p.relativePath(projectPath), err)
+	}
+	return proj, nil
+}
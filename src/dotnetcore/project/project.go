@@ -3,10 +3,8 @@ package project
 import (
 	"bytes"
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -20,17 +18,38 @@ import (
 )
 
 type CSProj struct {
+	Sdk           string `xml:"Sdk,attr"`
+	ToolsVersion  string `xml:"ToolsVersion,attr"`
 	PropertyGroup struct {
-		TargetFramework         string `xml:"TargetFramework"`
-		RuntimeFrameworkVersion string `xml:"RuntimeFrameworkVersion"`
-		AssemblyName            string `xml:"AssemblyName"`
-	}
-	ItemGroups []struct {
-		PackageReferences []struct {
-			Include string `xml:"Include,attr"`
-			Version string `xml:"Version,attr"`
-		} `xml:"PackageReference"`
-	} `xml:"ItemGroup"`
+		TargetFramework          string `xml:"TargetFramework"`
+		TargetFrameworks         string `xml:"TargetFrameworks"`
+		RuntimeFrameworkVersion  string `xml:"RuntimeFrameworkVersion"`
+		AssemblyName             string `xml:"AssemblyName"`
+		OutputType               string `xml:"OutputType"`
+		IsTestProject            string `xml:"IsTestProject"`
+		TargetFrameworkVersion   string `xml:"TargetFrameworkVersion"`
+		TargetPlatformIdentifier string `xml:"TargetPlatformIdentifier"`
+		UseWPF                   string `xml:"UseWPF"`
+		UseWindowsForms          string `xml:"UseWindowsForms"`
+		UseMaui                  string `xml:"UseMaui"`
+		NuGetLockFilePath        string `xml:"NuGetLockFilePath"`
+		PublishAot               string `xml:"PublishAot"`
+		InvariantGlobalization   string `xml:"InvariantGlobalization"`
+	}
+	ItemGroups []ItemGroup `xml:"ItemGroup"`
+}
+
+type ItemGroup struct {
+	Condition           string      `xml:"Condition,attr"`
+	PackageReferences   []Reference `xml:"PackageReference"`
+	FrameworkReferences []Reference `xml:"FrameworkReference"`
+	ProjectReferences   []Reference `xml:"ProjectReference"`
+}
+
+type Reference struct {
+	Include   string `xml:"Include,attr"`
+	Version   string `xml:"Version,attr"`
+	Condition string `xml:"Condition,attr"`
 }
 
 type Framework struct {
@@ -40,9 +59,11 @@ type Framework struct {
 
 type ConfigJSON struct {
 	RuntimeOptions struct {
-		Framework    Framework   `json:"framework"`
-		Frameworks   []Framework `json:"frameworks"`
-		ApplyPatches *bool       `json:"applyPatches"`
+		Framework        Framework              `json:"framework"`
+		Frameworks       []Framework            `json:"frameworks"`
+		ApplyPatches     *bool                  `json:"applyPatches"`
+		RollForward      string                 `json:"rollForward"`
+		ConfigProperties map[string]interface{} `json:"configProperties"`
 	} `json:"runtimeOptions"`
 }
 
@@ -55,61 +76,136 @@ type Installer interface {
 }
 
 type Project struct {
-	buildDir  string
-	depDir    string
-	depsIdx   string
-	manifest  Manifest
-	installer Installer
-	Log       *libbuildpack.Logger
+	buildDir            string
+	depDir              string
+	depsIdx             string
+	manifest            Manifest
+	installer           Installer
+	globalProperties    map[string]string
+	entryAssembly       string
+	bundle              string
+	bundleSearched      bool
+	mainPath            string
+	mainPathResolved    bool
+	startupProjectPath  string
+	installedFrameworks map[string]map[string]bool
+	Log                 *libbuildpack.Logger
+}
+
+var projFileRE = regexp.MustCompile(`\.([a-z]+proj)$`)
+
+var dependencyFrameworks = map[string]string{
+	"dotnet-runtime":    "Microsoft.NETCore.App",
+	"dotnet-aspnetcore": "Microsoft.AspNetCore.App",
 }
 
 func New(buildDir, depDir, depsIdx string, manifest Manifest, installer Installer, logger *libbuildpack.Logger) *Project {
 	return &Project{
-		buildDir:  buildDir,
-		depDir:    depDir,
-		depsIdx:   depsIdx,
-		manifest:  manifest,
-		installer: installer,
-		Log:       logger,
+		buildDir:            buildDir,
+		depDir:              depDir,
+		depsIdx:             depsIdx,
+		manifest:            manifest,
+		installer:           installer,
+		globalProperties:    map[string]string{"Configuration": PublishConfiguration()},
+		installedFrameworks: map[string]map[string]bool{},
+		Log:                 logger,
 	}
 }
 
+// PublishConfiguration returns the MSBuild configuration source-based apps
+// are published with
+func PublishConfiguration() string {
+	if os.Getenv("PUBLISH_RELEASE_CONFIG") == "true" {
+		return "Release"
+	}
+
+	return "Debug"
+}
+
+// SetGlobalProperty sets an MSBuild global property, such as the
+// TargetFramework passed to dotnet publish, for evaluating project files
+func (p *Project) SetGlobalProperty(name, value string) {
+	if current, ok := p.globalProperties[name]; !ok || current != value {
+		p.globalProperties[name] = value
+		p.mainPathResolved = false
+	}
+}
+
+// SetEntryAssembly sets the assembly that starts the app, in place of the one
+// named after the main project or its runtimeconfig.json, which also picks
+// the runtimeconfig.json of a published app that has several
+func (p *Project) SetEntryAssembly(name string) {
+	if p.entryAssembly != name {
+		p.entryAssembly = name
+		p.mainPathResolved = false
+	}
+}
+
+// IsPublished reports whether the app was pushed already published, which
+// leaves a runtimeconfig.json in the app directory, or only a single-file
+// bundle for apps published with PublishSingleFile
 func (p *Project) IsPublished() (bool, error) {
 	path, err := p.RuntimeConfigPath()
 	if err != nil {
 		return false, err
+	} else if path != "" {
+		return true, nil
 	}
-	return path != "", nil
+
+	bundle, err := p.publishedBundle()
+	if err != nil {
+		return false, err
+	}
+	return bundle != "", nil
 }
 
 func (p *Project) StartCommand() (string, error) {
+	name, err := p.mainAssemblyName()
+	if err != nil || name == "" {
+		return "", err
+	}
+
+	return p.publishedStartCommand(name)
+}
+
+// mainAssemblyName returns the name of the assembly that starts the app: the
+// start assembly set in buildpack.yml, or the one named by the app's
+// runtimeconfig.json, main project or single-file bundle
+func (p *Project) mainAssemblyName() (string, error) {
 	projectPath, err := p.MainPath()
 	if err != nil {
 		return "", err
 	} else if projectPath == "" {
-		return "", nil
+		if projectPath, err = p.publishedBundle(); err != nil || projectPath == "" {
+			return "", err
+		}
 	}
 	runtimeConfigRe := regexp.MustCompile(`\.(runtimeconfig\.json)$`)
-	projRe := regexp.MustCompile(`\.([a-z]+proj)$`)
 
-	if runtimeConfigRe.MatchString(projectPath) {
+	if p.entryAssembly != "" {
+		projectPath = p.entryAssembly
+	} else if runtimeConfigRe.MatchString(projectPath) {
 		projectPath = runtimeConfigRe.ReplaceAllString(projectPath, "")
 		projectPath = filepath.Base(projectPath)
-	} else if projRe.MatchString(projectPath) {
+	} else if projFileRE.MatchString(projectPath) {
 		proj, err := p.parseProj()
 		if err != nil {
 			return "", err
 		}
 
-		if proj.PropertyGroup.AssemblyName != "" {
-			projectPath = projRe.ReplaceAllString(proj.PropertyGroup.AssemblyName, "")
-		} else {
-			projectPath = projRe.ReplaceAllString(projectPath, "")
-			projectPath = filepath.Base(projectPath)
-		}
+		projectPath = proj.assemblyName(projectPath)
 	}
 
-	return p.publishedStartCommand(projectPath)
+	return projectPath, nil
+}
+
+// assemblyName returns the name of the assembly a project at projectPath
+// builds
+func (proj CSProj) assemblyName(projectPath string) string {
+	if proj.PropertyGroup.AssemblyName != "" {
+		return projFileRE.ReplaceAllString(proj.PropertyGroup.AssemblyName, "")
+	}
+	return filepath.Base(projFileRE.ReplaceAllString(projectPath, ""))
 }
 
 func (p *Project) FindMatchingFrameworkVersion(name, version string, applyPatches *bool) (string, error) {
@@ -131,7 +227,11 @@ func (p *Project) FindMatchingFrameworkVersionWithPreview(name, version string,
 }
 
 func (p *Project) GetVersionFromDepsJSON(library string) (string, error) {
-	depsJSONFiles, err := filepath.Glob(filepath.Join(p.buildDir, "*.deps.json"))
+	return p.versionFromDepsJSON(p.buildDir, library)
+}
+
+func (p *Project) versionFromDepsJSON(dir, library string) (string, error) {
+	depsJSONFiles, err := filepath.Glob(filepath.Join(dir, "*.deps.json"))
 	if err != nil {
 		return "", err
 	}
@@ -194,7 +294,7 @@ func (p *Project) ProjectFilePaths() ([]string, error) {
 			return filepath.SkipDir
 		}
 
-		if strings.HasSuffix(path, ".csproj") || strings.HasSuffix(path, ".vbproj") || strings.HasSuffix(path, ".fsproj") {
+		if isProjectFile(path) {
 			paths = append(paths, path)
 		}
 
@@ -222,17 +322,50 @@ func (p *Project) IsFsharp() (bool, error) {
 }
 
 func (p *Project) RuntimeConfigPath() (string, error) {
-	if configFiles, err := filepath.Glob(filepath.Join(p.buildDir, "*.runtimeconfig.json")); err != nil {
+	return runtimeConfigPath(p.buildDir, p.entryAssembly)
+}
+
+// runtimeConfigPath returns the runtimeconfig.json in dir, which is the one of
+// entryAssembly when there are several
+func runtimeConfigPath(dir, entryAssembly string) (string, error) {
+	if configFiles, err := filepath.Glob(filepath.Join(dir, "*.runtimeconfig.json")); err != nil {
 		return "", err
 	} else if len(configFiles) == 1 {
 		return configFiles[0], nil
 	} else if len(configFiles) > 1 {
-		return "", fmt.Errorf("multiple *.runtimeconfig.json files present")
+		if entryAssembly == "" {
+			return "", fmt.Errorf("multiple *.runtimeconfig.json files present, set start.assembly in buildpack.yml to choose the one that starts the app")
+		}
+
+		path := filepath.Join(dir, entryAssembly+".runtimeconfig.json")
+		if exists, err := libbuildpack.FileExists(path); err != nil {
+			return "", err
+		} else if !exists {
+			return "", fmt.Errorf("multiple *.runtimeconfig.json files present, but none for the start assembly %s", entryAssembly)
+		}
+		return path, nil
 	}
 	return "", nil
 }
 
+// MainPath returns the runtimeconfig.json of a published app, or the project
+// file to publish. It is resolved once, since it is needed throughout staging.
 func (p *Project) MainPath() (string, error) {
+	if p.mainPathResolved {
+		return p.mainPath, nil
+	}
+
+	mainPath, err := p.resolveMainPath()
+	if err != nil {
+		return "", err
+	}
+	p.mainPath, p.mainPathResolved = mainPath, true
+	return mainPath, nil
+}
+
+// resolveMainPath finds the main path that MainPath remembers until a global
+// property or the entry assembly it depends on changes
+func (p *Project) resolveMainPath() (string, error) {
 	runtimeConfigFile, err := p.RuntimeConfigPath()
 	if err != nil {
 		return "", err
@@ -269,7 +402,7 @@ func (p *Project) MainPath() (string, error) {
 			return filepath.Join(p.buildDir, strings.Trim(project.String(), ".")), nil
 		}
 
-		return "", fmt.Errorf("multiple paths: %v contain a project file, but no .deployment file was used", paths)
+		return p.startupProject(paths)
 	}
 
 	return "", nil
@@ -295,18 +428,90 @@ func (p *Project) IsFDD() (bool, error) {
 }
 
 func (p *Project) IsSourceBased() (bool, error) {
-	path, err := p.RuntimeConfigPath()
+	published, err := p.IsPublished()
 	if err != nil {
 		return false, err
 	}
 
-	return path == "", nil
+	return !published, nil
+}
+
+// IsNativeAot reports whether a source-based app publishes with Native AOT,
+// which compiles it to a native executable that needs no .NET runtime
+func (p *Project) IsNativeAot() (bool, error) {
+	mainPath, err := p.MainPath()
+	if err != nil || !isProjectFile(mainPath) {
+		return false, err
+	}
+
+	proj, err := p.parseProj()
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(proj.PropertyGroup.PublishAot, "true"), nil
 }
 
 func (p *Project) FDDInstallFrameworks() error {
-	path, err := p.RuntimeConfigPath()
+	return p.installFrameworks(p.buildDir, p.entryAssembly)
+}
+
+// InstallPublishedFrameworks installs the shared frameworks required by a
+// source-based app that was published framework-dependent
+func (p *Project) InstallPublishedFrameworks() error {
+	return p.installFrameworks(filepath.Join(p.depDir, "dotnet_publish"), p.entryAssembly)
+}
+
+// RemoveUnusedRuntimeFiles removes the parts of the SDK that a
+// framework-dependent app does not need at runtime, along with every shared
+// framework version that was not installed for the app's runtimeconfig.json
+func (p *Project) RemoveUnusedRuntimeFiles() error {
+	dotnetRoot := filepath.Join(p.depDir, "dotnet-sdk")
+	for _, dir := range []string{"sdk", "sdk-manifests", "packs", "templates", "library-packs", "metadata"} {
+		if err := os.RemoveAll(filepath.Join(dotnetRoot, dir)); err != nil {
+			return err
+		}
+	}
+
+	sharedDir := filepath.Join(dotnetRoot, "shared")
+	frameworks, err := os.ReadDir(sharedDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, framework := range frameworks {
+		versions, err := os.ReadDir(filepath.Join(sharedDir, framework.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, version := range versions {
+			if p.installedFrameworks[framework.Name()][version.Name()] {
+				continue
+			}
+
+			p.Log.Debug("Removing unused framework %s %s", framework.Name(), version.Name())
+			if err := os.RemoveAll(filepath.Join(sharedDir, framework.Name(), version.Name())); err != nil {
+				return err
+			}
+		}
+
+		if len(p.installedFrameworks[framework.Name()]) == 0 {
+			if err := os.RemoveAll(filepath.Join(sharedDir, framework.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Project) installFrameworks(dir, entryAssembly string) error {
+	path, err := runtimeConfigPath(dir, entryAssembly)
 	if err != nil {
 		return err
+	} else if path == "" {
+		return fmt.Errorf("no *.runtimeconfig.json file present in %s", dir)
 	}
 
 	runtimeConfig, err := parseRuntimeConfig(path)
@@ -315,27 +520,84 @@ func (p *Project) FDDInstallFrameworks() error {
 	}
 
 	applyPatches := runtimeConfig.RuntimeOptions.ApplyPatches
+	policy, err := rollForwardPolicy(runtimeConfig)
+	if err != nil {
+		return err
+	}
 
 	for _, fw := range append([]Framework{runtimeConfig.RuntimeOptions.Framework}, runtimeConfig.RuntimeOptions.Frameworks...) {
 		switch fw.Name {
 		case "":
 			continue
 		case "Microsoft.NETCore.App":
-			if err := p.fddInstallFrameworksNETCoreApp(fw.Name, fw.Version, applyPatches); err != nil {
+			if err := p.fddInstallFrameworksNETCoreApp(dir, fw.Version, policy, applyPatches); err != nil {
 				return err
 			}
 		case "Microsoft.AspNetCore.App":
-			if err := p.fddInstallFrameworksAspNetCoreApp(fw.Name, fw.Version, applyPatches); err != nil {
+			if err := p.fddInstallFrameworksAspNetCoreApp(fw.Name, fw.Version, policy, applyPatches); err != nil {
 				return err
 			}
 		default:
-			return fmt.Errorf("invalid framework '%s' specified in %s", fw.Name, filepath.Base(path))
+			return checkSharedFramework(fw.Name, filepath.Base(path))
 		}
 	}
 	return nil
 }
 
-func (p *Project) SourceInstallDotnetRuntime() error {
+// SourceTargetFramework returns the target framework a source-based app is
+// published for. For multi-targeted projects this is requested, when set, or
+// otherwise the highest of the project's TargetFrameworks that the buildpack
+// has a dotnet-runtime for.
+func (p *Project) SourceTargetFramework(requested string) (string, error) {
+	proj, err := p.parseProj()
+	if err != nil {
+		return "", err
+	}
+
+	frameworks := proj.targetFrameworks()
+	if requested != "" {
+		if len(frameworks) == 0 {
+			return requested, nil
+		}
+		for _, fw := range frameworks {
+			if strings.EqualFold(fw, requested) {
+				return fw, nil
+			}
+		}
+		return "", fmt.Errorf("target framework %s is not one of the project's target frameworks %v", requested, frameworks)
+	}
+
+	if len(frameworks) <= 1 {
+		return strings.Join(frameworks, ""), nil
+	}
+
+	runtimeVersions := p.manifest.AllDependencyVersions("dotnet-runtime")
+
+	var highest string
+	var highestVersion semver.Version
+	for _, fw := range frameworks {
+		version := targetFrameworkVersion(fw)
+		if version == "" || !isLinuxFramework(fw) {
+			continue
+		}
+
+		if _, err := FindMatchingVersionWithPreview(version+".x", runtimeVersions); err != nil {
+			continue
+		}
+
+		if v, err := semver.ParseTolerant(version); err == nil && (highest == "" || v.GT(highestVersion)) {
+			highest, highestVersion = fw, v
+		}
+	}
+
+	if highest == "" {
+		return "", fmt.Errorf("none of the project's target frameworks %v are supported by this buildpack", frameworks)
+	}
+
+	return highest, nil
+}
+
+func (p *Project) SourceInstallDotnetRuntime(targetFramework string) error {
 	proj, err := p.parseProj()
 	if err != nil {
 		return err
@@ -351,13 +613,14 @@ func (p *Project) SourceInstallDotnetRuntime() error {
 			}
 		}
 	} else {
-		// This regular expression matches on 'net<x>.<y>',
-		// 'net<x>.<y>-<platform>' & 'netcoreapp<x>.<y>', where <x> may be
-		// multiple digits (e.g. 'net10.0').
-		targetFrameworkRE := regexp.MustCompile(`net(?:coreapp)?(\d+\.\d)(?:\w+)?`)
-		matches := targetFrameworkRE.FindStringSubmatch(proj.PropertyGroup.TargetFramework)
-		if len(matches) == 2 {
-			runtimeVersionMinor := matches[1]
+		if targetFramework == "" {
+			targetFramework, err = p.SourceTargetFramework("")
+			if err != nil {
+				return err
+			}
+		}
+
+		if runtimeVersionMinor := targetFrameworkVersion(targetFramework); runtimeVersionMinor != "" {
 			runtimeVersion, err = p.rollForward("dotnet-runtime", runtimeVersionMinor)
 			if err != nil {
 				return err
@@ -367,21 +630,79 @@ func (p *Project) SourceInstallDotnetRuntime() error {
 		}
 	}
 
-	err = p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: runtimeVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	)
-
+	usesAspNetCore, err := p.usesAspNetCore(proj, map[string]bool{})
 	if err != nil {
 		return err
 	}
 
+	if usesAspNetCore {
+		err = p.installer.InstallDependency(
+			libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: runtimeVersion},
+			filepath.Join(p.depDir, "dotnet-sdk"),
+		)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		p.Log.Debug("Project does not use ASP.NET Core, skipping dotnet-aspnetcore")
+	}
+
 	return p.installer.InstallDependency(
 		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtimeVersion},
 		filepath.Join(p.depDir, "dotnet-sdk"),
 	)
 }
 
+// usesAspNetCore reports whether a project, or any project it references,
+// needs the ASP.NET Core shared framework: web SDK projects and projects with
+// a FrameworkReference or (pre 3.0) PackageReference to it.
+func (p *Project) usesAspNetCore(proj CSProj, visited map[string]bool) (bool, error) {
+	for _, sdk := range proj.sdks() {
+		if sdk == "Microsoft.NET.Sdk.Web" {
+			return true, nil
+		}
+	}
+
+	for _, ig := range proj.ItemGroups {
+		for _, fr := range ig.FrameworkReferences {
+			if fr.Include == "Microsoft.AspNetCore.App" {
+				return true, nil
+			}
+		}
+		for _, pr := range ig.PackageReferences {
+			if pr.Include == "Microsoft.AspNetCore.App" || pr.Include == "Microsoft.AspNetCore.All" {
+				return true, nil
+			}
+		}
+	}
+
+	for _, ig := range proj.ItemGroups {
+		for _, ref := range ig.ProjectReferences {
+			if visited[ref.Include] {
+				continue
+			}
+			visited[ref.Include] = true
+
+			if exists, err := libbuildpack.FileExists(ref.Include); err != nil {
+				return false, err
+			} else if !exists {
+				continue
+			}
+
+			referenced, err := loadProject(ref.Include, p.buildDir, p.globalProperties)
+			if err != nil {
+				return false, err
+			}
+			if uses, err := p.usesAspNetCore(referenced, visited); err != nil || uses {
+				return uses, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
 func (p *Project) getVersionFromAssetFile(path, library string) (string, bool, error) {
 	depsBytes, err := os.ReadFile(path)
 	if err != nil {
@@ -473,25 +794,21 @@ func (p *Project) installAspNetCoreDependency(version string, latestPatch bool)
 		return nil
 	}
 
-	return p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: rollForwardVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	)
+	return p.installFramework("dotnet-aspnetcore", rollForwardVersion)
 }
 
 func (p *Project) publishedStartCommand(projectPath string) (string, error) {
-	var publishedPath string
-	var runtimePath string
-
 	if published, err := p.IsPublished(); err != nil {
 		return "", err
 	} else if published {
-		publishedPath = p.buildDir
-		runtimePath = "${HOME}"
-	} else {
-		publishedPath = filepath.Join(p.depDir, "dotnet_publish")
-		runtimePath = filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish")
+		return startCommandIn(p.buildDir, "${HOME}", projectPath)
 	}
+	return startCommandIn(filepath.Join(p.depDir, "dotnet_publish"), filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish"), projectPath)
+}
+
+// startCommandIn returns the command that starts the assembly projectPath
+// published to publishedPath, which is found at runtimePath when the app runs
+func startCommandIn(publishedPath, runtimePath, projectPath string) (string, error) {
 
 	if exists, err := libbuildpack.FileExists(filepath.Join(publishedPath, projectPath)); err != nil {
 		return "", err
@@ -507,6 +824,18 @@ func (p *Project) publishedStartCommand(projectPath string) (string, error) {
 	} else if exists {
 		return fmt.Sprintf("%s.dll", filepath.Join(runtimePath, projectPath)), nil
 	}
+
+	// Single-file apps only have the bundle executable, which is named after
+	// the assembly unless the project sets AssemblyName in a way that cannot
+	// be evaluated here
+	if bundle, err := singleFileBundle(publishedPath); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("looking for a single-file bundle: %v", err)
+	} else if bundle != "" {
+		if err := os.Chmod(filepath.Join(publishedPath, bundle), 0755); err != nil {
+			return "", err
+		}
+		return filepath.Join(runtimePath, bundle), nil
+	}
 	return "", nil
 }
 
@@ -542,20 +871,17 @@ func (p *Project) rollForward(name, version string) (string, error) {
 	return rollForwardVersion, nil
 }
 
-func (p *Project) fddInstallFrameworksNETCoreApp(frameworkName, frameworkVersion string, applyPatches *bool) error {
-	runtimeVersion, err := p.FindMatchingFrameworkVersion("dotnet-runtime", frameworkVersion, applyPatches)
+func (p *Project) fddInstallFrameworksNETCoreApp(dir, frameworkVersion, policy string, applyPatches *bool) error {
+	runtimeVersion, err := p.frameworkVersion("dotnet-runtime", frameworkVersion, policy, applyPatches)
 	if err != nil {
 		return err
 	}
 
-	if err = p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtimeVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	); err != nil {
+	if err = p.installFramework("dotnet-runtime", runtimeVersion); err != nil {
 		return err
 	}
 
-	aspNetCoreVersion, err := p.GetVersionFromDepsJSON("Microsoft.AspNetCore.App")
+	aspNetCoreVersion, err := p.versionFromDepsJSON(dir, "Microsoft.AspNetCore.App")
 	if _, ok := err.(*libraryMissingError); err != nil && !ok {
 		return err
 	} else if ok {
@@ -565,16 +891,13 @@ func (p *Project) fddInstallFrameworksNETCoreApp(frameworkName, frameworkVersion
 	return p.installAspNetCoreDependency(aspNetCoreVersion, false)
 }
 
-func (p *Project) fddInstallFrameworksAspNetCoreApp(frameworkName, frameworkVersion string, applyPatches *bool) error {
-	aspNetCoreVersion, err := p.FindMatchingFrameworkVersionWithPreview("dotnet-aspnetcore", frameworkVersion, applyPatches)
+func (p *Project) fddInstallFrameworksAspNetCoreApp(frameworkName, frameworkVersion, policy string, applyPatches *bool) error {
+	aspNetCoreVersion, err := p.frameworkVersion("dotnet-aspnetcore", frameworkVersion, policy, applyPatches)
 	if err != nil {
 		return err
 	}
 
-	if err = p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: aspNetCoreVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	); err != nil {
+	if err = p.installFramework("dotnet-aspnetcore", aspNetCoreVersion); err != nil {
 		return err
 	}
 
@@ -604,19 +927,62 @@ func (p *Project) fddInstallFrameworksAspNetCoreApp(frameworkName, frameworkVers
 		return nil
 	}
 
-	runtimeVersion, err := p.FindMatchingFrameworkVersion(
+	runtimeVersion, err := p.frameworkVersion(
 		"dotnet-runtime",
 		fw.Version,
+		policy,
 		aspNetCoreConfigJSON.RuntimeOptions.ApplyPatches,
 	)
 	if err != nil {
 		return err
 	}
 
-	return p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtimeVersion},
+	return p.installFramework("dotnet-runtime", runtimeVersion)
+}
+
+// frameworkVersion returns the version of dependency to install for a
+// framework reference in a runtimeconfig.json. Without a roll forward policy
+// it keeps the buildpack's historical behavior of using the latest patch.
+func (p *Project) frameworkVersion(dependency, version, policy string, applyPatches *bool) (string, error) {
+	if policy == "" {
+		resolved, err := p.FindMatchingFrameworkVersionWithPreview(dependency, version, applyPatches)
+		if err != nil {
+			return "", err
+		}
+
+		rule := "latest patch"
+		if applyPatches != nil && !*applyPatches {
+			rule = "applyPatches false: exact version"
+		}
+		p.Log.Info("Using %s %s for framework version %s (%s)", dependency, resolved, version, rule)
+		return resolved, nil
+	}
+
+	resolved, rule, err := rollForwardVersion(policy, version, applyPatches == nil || *applyPatches, p.manifest.AllDependencyVersions(dependency))
+	if err != nil {
+		return "", fmt.Errorf("could not find %s: %v", dependency, err)
+	}
+
+	p.Log.Info("Using %s %s for framework version %s (%s)", dependency, resolved, version, rule)
+	return resolved, nil
+}
+
+// installFramework installs the dependency for a shared framework and records
+// its version so that RemoveUnusedRuntimeFiles keeps it
+func (p *Project) installFramework(dependency, version string) error {
+	if err := p.installer.InstallDependency(
+		libbuildpack.Dependency{Name: dependency, Version: version},
 		filepath.Join(p.depDir, "dotnet-sdk"),
-	)
+	); err != nil {
+		return err
+	}
+
+	name := dependencyFrameworks[dependency]
+	if p.installedFrameworks[name] == nil {
+		p.installedFrameworks[name] = map[string]bool{}
+	}
+	p.installedFrameworks[name][version] = true
+	return nil
 }
 
 func (p *Project) parseProj() (CSProj, error) {
@@ -628,21 +994,33 @@ func (p *Project) parseProj() (CSProj, error) {
 	if _, err = os.Stat(mainPath); os.IsNotExist(err) {
 		return CSProj{}, nil
 	}
-	projFile, err := os.Open(mainPath)
-	if err != nil {
-		return CSProj{}, err
+
+	return loadProject(mainPath, p.buildDir, p.globalProperties)
+}
+
+func (proj CSProj) targetFrameworks() []string {
+	if proj.PropertyGroup.TargetFramework != "" {
+		return []string{proj.PropertyGroup.TargetFramework}
 	}
-	defer projFile.Close()
-	projBytes, err := io.ReadAll(projFile)
-	if err != nil {
-		return CSProj{}, err
+
+	var frameworks []string
+	for _, fw := range strings.Split(proj.PropertyGroup.TargetFrameworks, ";") {
+		if fw = strings.TrimSpace(fw); fw != "" {
+			frameworks = append(frameworks, fw)
+		}
 	}
-	obj := CSProj{}
+	return frameworks
+}
 
-	if err := xml.Unmarshal(projBytes, &obj); err != nil {
-		return CSProj{}, err
+// targetFrameworkVersion returns the <x>.<y> runtime version of a target
+// framework moniker. It matches on 'net<x>.<y>', 'net<x>.<y>-<platform>' &
+// 'netcoreapp<x>.<y>', where <x> may be multiple digits (e.g. 'net10.0').
+func targetFrameworkVersion(targetFramework string) string {
+	targetFrameworkRE := regexp.MustCompile(`net(?:coreapp)?(\d+\.\d)(?:\w+)?`)
+	if matches := targetFrameworkRE.FindStringSubmatch(targetFramework); len(matches) == 2 {
+		return matches[1]
 	}
-	return obj, nil
+	return ""
 }
 
 func sanitizeJsonConfig(runtimeConfigPath string) ([]byte, error) {
@@ -676,6 +1054,18 @@ func parseRuntimeConfig(runtimeConfigPath string) (ConfigJSON, error) {
 	return obj, nil
 }
 
+// configPropertyTrue reports whether the runtime setting name is turned on in
+// the configProperties of a runtimeconfig.json
+func configPropertyTrue(runtimeConfig ConfigJSON, name string) bool {
+	switch value := runtimeConfig.RuntimeOptions.ConfigProperties[name].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	}
+	return false
+}
+
 func findFramework(name string, frameworks []Framework) Framework {
 	for _, fw := range frameworks {
 		if fw.Name == name {
@@ -2,6 +2,7 @@ package project_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -133,6 +134,54 @@ var _ = Describe("Project", func() {
 			})
 		})
 
+		Context("The app was pushed as a published single-file bundle", func() {
+			BeforeEach(func() {
+				bundle := appHost(0x4a2f10)
+				Expect(os.WriteFile(filepath.Join(buildDir, "Fred.Api"), bundle, 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "appsettings.json"), []byte("{}"), 0644)).To(Succeed())
+			})
+
+			It("is published and starts the bundle", func() {
+				Expect(subject.IsPublished()).To(BeTrue())
+				Expect(subject.IsSourceBased()).To(BeFalse())
+
+				startCmd, err := subject.StartCommand()
+				Expect(err).To(BeNil())
+				Expect(startCmd).To(Equal(filepath.Join("${HOME}", "Fred.Api")))
+			})
+		})
+
+		Context("The app was pushed with an apphost that is not a bundle", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Fred.Api"), appHost(0), 0755)).To(Succeed())
+			})
+
+			It("is not published", func() {
+				Expect(subject.IsPublished()).To(BeFalse())
+			})
+		})
+
+		Context("The published app has several runtimeconfig.json files", func() {
+			BeforeEach(func() {
+				for _, name := range []string{"Fred.Api", "Fred.Tools"} {
+					Expect(os.WriteFile(filepath.Join(buildDir, name+".runtimeconfig.json"), []byte(""), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, name+".dll"), []byte(""), 0644)).To(Succeed())
+				}
+			})
+
+			It("needs a start assembly", func() {
+				_, err := subject.StartCommand()
+				Expect(err).To(MatchError("multiple *.runtimeconfig.json files present, set start.assembly in buildpack.yml to choose the one that starts the app"))
+			})
+
+			It("starts the start assembly", func() {
+				subject.SetEntryAssembly("Fred.Api")
+				startCmd, err := subject.StartCommand()
+				Expect(err).To(BeNil())
+				Expect(startCmd).To(Equal(filepath.Join("${HOME}", "Fred.Api.dll")))
+			})
+		})
+
 		Context("The project is NOT published", func() {
 			Context("The csproj file does not have an AssemblyName tag", func() {
 				BeforeEach(func() {
@@ -173,6 +222,32 @@ var _ = Describe("Project", func() {
 						Expect(startCmd).To(Equal(""))
 					})
 				})
+
+				Context("Only a single-file bundle with another name exists", func() {
+					BeforeEach(func() {
+						bundle := appHost(0x4a2f10)
+						Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "Fred.Api"), bundle, 0644)).To(Succeed())
+						Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "other"), []byte("\x7fELF other"), 0755)).To(Succeed())
+					})
+
+					It("returns the path to the bundle", func() {
+						startCmd, err := subject.StartCommand()
+						Expect(err).To(BeNil())
+						Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "Fred.Api")))
+					})
+				})
+
+				Context("Only an apphost that is not a bundle exists", func() {
+					BeforeEach(func() {
+						Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "Fred.Api"), appHost(0), 0755)).To(Succeed())
+					})
+
+					It("returns an empty string", func() {
+						startCmd, err := subject.StartCommand()
+						Expect(err).To(BeNil())
+						Expect(startCmd).To(Equal(""))
+					})
+				})
 			})
 
 			Context("The csproj file has an AssemblyName tag", func() {
@@ -195,6 +270,27 @@ var _ = Describe("Project", func() {
 					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "f.red")))
 				})
 			})
+
+			Context("The AssemblyName is set in a Directory.Build.props file", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "src", "fred"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<AssemblyName>barney</AssemblyName>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "src", "fred", "fred.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+					Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "barney"), []byte(""), 0755)).To(Succeed())
+				})
+
+				It("returns a start command with the AssemblyName from Directory.Build.props", func() {
+					startCmd, err := subject.StartCommand()
+					Expect(err).To(BeNil())
+					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "barney")))
+				})
+			})
 		})
 
 		Context("mainPath could not be determined", func() {
@@ -382,6 +478,43 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("TestProjectPaths", func() {
+		It("returns the test projects", func() {
+			for name, contents := range map[string]string{
+				"src/app/app.csproj":        `<Project Sdk="Microsoft.NET.Sdk.Web" />`,
+				"test/unit/unit.csproj":     `<Project Sdk="Microsoft.NET.Sdk"><ItemGroup><PackageReference Include="Microsoft.NET.Test.Sdk" Version="17.9.0" /></ItemGroup></Project>`,
+				"test/mstest/mstest.csproj": `<Project Sdk="MSTest.Sdk/3.3.1" />`,
+				"test/e2e/e2e.csproj":       `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><IsTestProject>true</IsTestProject></PropertyGroup></Project>`,
+			} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+			}
+
+			Expect(subject.TestProjectPaths()).To(ConsistOf(
+				filepath.Join(buildDir, "test", "unit", "unit.csproj"),
+				filepath.Join(buildDir, "test", "mstest", "mstest.csproj"),
+				filepath.Join(buildDir, "test", "e2e", "e2e.csproj"),
+			))
+		})
+	})
+
+	Describe("IsNativeAot", func() {
+		It("returns true when the project sets PublishAot", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><PublishAot>true</PublishAot></PropertyGroup></Project>`), 0644)).To(Succeed())
+			Expect(subject.IsNativeAot()).To(BeTrue())
+		})
+
+		It("returns false for other projects", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(subject.IsNativeAot()).To(BeFalse())
+		})
+
+		It("returns false for published apps", func() {
+			createRuntimeConfig("", "")
+			Expect(subject.IsNativeAot()).To(BeFalse())
+		})
+	})
+
 	Describe("IsSourceBased", func() {
 		BeforeEach(func() {
 			for _, name := range []string{
@@ -522,6 +655,128 @@ var _ = Describe("Project", func() {
 				})
 			})
 		})
+
+		Context("More than one project file and no .deployment file", func() {
+			writeProject := func(name, contents string) {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+			}
+
+			BeforeEach(func() {
+				writeProject("src/web/web.csproj", `<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`)
+				writeProject("src/lib/lib.csproj", `<Project Sdk="Microsoft.NET.Sdk"></Project>`)
+				writeProject("test/web.tests/web.tests.csproj", `
+<Project Sdk="Microsoft.NET.Sdk">
+	<ItemGroup>
+		<PackageReference Include="Microsoft.NET.Test.Sdk" Version="17.0.0" />
+	</ItemGroup>
+</Project>`)
+				writeProject("test/lib.tests/lib.tests.csproj", `
+<Project Sdk="Microsoft.NET.Sdk">
+	<PropertyGroup>
+		<OutputType>Exe</OutputType>
+		<IsTestProject>true</IsTestProject>
+	</PropertyGroup>
+</Project>`)
+			})
+
+			It("returns the only executable project", func() {
+				path, err := subject.MainPath()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(path).To(Equal(filepath.Join(buildDir, "src", "web", "web.csproj")))
+			})
+
+			It("picks the startup project once", func() {
+				Expect(os.Setenv("BP_DEBUG", "true")).To(Succeed())
+				DeferCleanup(os.Unsetenv, "BP_DEBUG")
+
+				for i := 0; i < 3; i++ {
+					path, err := subject.MainPath()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(path).To(Equal(filepath.Join(buildDir, "src", "web", "web.csproj")))
+				}
+				subject.SetGlobalProperty("Configuration", "Release")
+				_, err := subject.MainPath()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Count(buffer.String(), "Using startup project src/web/web.csproj")).To(Equal(1))
+			})
+
+			It("picks the startup project again when a global property changes", func() {
+				_, err := subject.MainPath()
+				Expect(err).NotTo(HaveOccurred())
+
+				writeProject("tools/migrator/migrator.csproj", `
+<Project Sdk="Microsoft.NET.Sdk">
+	<PropertyGroup>
+		<OutputType Condition="'$(Configuration)' == 'Release'">Exe</OutputType>
+	</PropertyGroup>
+</Project>`)
+				path, err := subject.MainPath()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(path).To(Equal(filepath.Join(buildDir, "src", "web", "web.csproj")))
+
+				subject.SetGlobalProperty("Configuration", "Release")
+				_, err = subject.MainPath()
+				Expect(err).To(MatchError(ContainSubstring("multiple executable or web projects found")))
+			})
+
+			Context("and more than one executable project", func() {
+				BeforeEach(func() {
+					writeProject("tools/migrator/migrator.csproj", `
+<Project Sdk="Microsoft.NET.Sdk">
+	<PropertyGroup>
+		<OutputType>Exe</OutputType>
+	</PropertyGroup>
+</Project>`)
+				})
+
+				It("returns an error listing the candidates", func() {
+					_, err := subject.MainPath()
+					Expect(err).To(MatchError(ContainSubstring("multiple executable or web projects found: src/web/web.csproj, tools/migrator/migrator.csproj")))
+					Expect(err).To(MatchError(ContainSubstring("project = src/web/web.csproj")))
+				})
+
+				Context("and a .sln file listing only one of them", func() {
+					BeforeEach(func() {
+						writeProject("app.sln", `
+Microsoft Visual Studio Solution File, Format Version 12.00
+Project("{9A19103F-16F7-4668-BE54-9A1E7A4F7556}") = "web", "src\web\web.csproj", "{11111111-1111-1111-1111-111111111111}"
+EndProject
+Project("{2150E333-8FDC-42A3-9474-1A3956D46DE8}") = "test", "test", "{22222222-2222-2222-2222-222222222222}"
+EndProject
+Project("{9A19103F-16F7-4668-BE54-9A1E7A4F7556}") = "web.tests", "test\web.tests\web.tests.csproj", "{33333333-3333-3333-3333-333333333333}"
+EndProject
+`)
+					})
+
+					It("returns the executable project from the solution", func() {
+						path, err := subject.MainPath()
+						Expect(err).NotTo(HaveOccurred())
+						Expect(path).To(Equal(filepath.Join(buildDir, "src", "web", "web.csproj")))
+					})
+				})
+
+				Context("and a .slnx file listing only one of them", func() {
+					BeforeEach(func() {
+						writeProject("app.slnx", `
+<Solution>
+	<Folder Name="/tools/">
+		<Project Path="tools/migrator/migrator.csproj" />
+	</Folder>
+	<Folder Name="/src/">
+		<Project Path="src/lib/lib.csproj" />
+	</Folder>
+</Solution>`)
+					})
+
+					It("returns the executable project from the solution", func() {
+						path, err := subject.MainPath()
+						Expect(err).NotTo(HaveOccurred())
+						Expect(path).To(Equal(filepath.Join(buildDir, "tools", "migrator", "migrator.csproj")))
+					})
+				})
+			})
+		})
 	})
 
 	Describe("FDDInstallFrameworks", func() {
@@ -619,6 +874,120 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("FDDInstallFrameworks with a roll forward policy", func() {
+		createRollForwardConfig := func(policy, applyPatches string) {
+			content := fmt.Sprintf(`{ "runtimeOptions": { "framework": { "name": "Microsoft.NETCore.App", "version": "6.0.0" }, "rollForward": "%s", "applyPatches": %s } }`, policy, applyPatches)
+			Expect(os.WriteFile(filepath.Join(buildDir, "test.runtimeconfig.json"), []byte(content), 0644)).To(Succeed())
+			createDepsJSON("", "", true)
+		}
+
+		DescribeTable("installs the dotnet-runtime the host would choose",
+			func(policy, applyPatches string, versions []string, expected, rule string) {
+				createRollForwardConfig(policy, applyPatches)
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return(versions)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: expected}, depsPath)
+
+				Expect(subject.FDDInstallFrameworks()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Using dotnet-runtime %s for framework version 6.0.0 (%s)", expected, rule)))
+			},
+			Entry("Disable", "Disable", "true", []string{"6.0.0", "6.0.5"}, "6.0.0", "rollForward Disable: exact version"),
+			Entry("LatestPatch", "LatestPatch", "true", []string{"6.0.1", "6.0.5", "6.1.2"}, "6.0.5", "rollForward LatestPatch: latest patch of 6.0"),
+			Entry("Minor with the requested minor", "Minor", "true", []string{"6.0.1", "6.0.5", "6.1.2"}, "6.0.5", "rollForward Minor: latest patch of 6.0"),
+			Entry("Minor without the requested minor", "minor", "true", []string{"6.1.1", "6.1.2", "6.2.0", "7.0.0"}, "6.1.2", "rollForward Minor: latest patch of 6.1"),
+			Entry("Minor with applyPatches false", "Minor", "false", []string{"6.1.1", "6.1.2"}, "6.1.1", "rollForward Minor with applyPatches false: lowest patch of 6.1"),
+			Entry("LatestMinor", "LatestMinor", "true", []string{"6.0.1", "6.2.3", "7.0.0"}, "6.2.3", "rollForward LatestMinor: latest minor version of 6"),
+			Entry("Major without the requested major", "Major", "true", []string{"5.0.17", "7.0.1", "7.0.4", "7.1.0", "8.0.0"}, "7.0.4", "rollForward Major: latest patch of 7.0"),
+			Entry("LatestMajor", "LatestMajor", "true", []string{"6.0.1", "7.0.1", "8.0.4", "9.0.0-preview.1"}, "8.0.4", "rollForward LatestMajor: latest version"),
+		)
+
+		Context("when no version satisfies the policy", func() {
+			It("returns an error", func() {
+				createRollForwardConfig("LatestPatch", "true")
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"5.0.17", "6.1.0"})
+
+				Expect(subject.FDDInstallFrameworks()).To(MatchError(ContainSubstring("could not find dotnet-runtime: no version matching 6.0.0 with roll forward policy LatestPatch")))
+			})
+		})
+
+		Context("when DOTNET_ROLL_FORWARD is set", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("DOTNET_ROLL_FORWARD", "LatestMajor")).To(Succeed())
+				DeferCleanup(os.Unsetenv, "DOTNET_ROLL_FORWARD")
+			})
+
+			It("uses it when the runtimeconfig.json does not set a policy", func() {
+				createRuntimeConfig("Microsoft.NETCore.App", "6.0.0")
+				createDepsJSON("", "", true)
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"6.0.1", "8.0.4"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.4"}, depsPath)
+
+				Expect(subject.FDDInstallFrameworks()).To(Succeed())
+			})
+
+			It("prefers the policy in the runtimeconfig.json", func() {
+				createRollForwardConfig("Disable", "true")
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"6.0.0", "8.0.4"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.0.0"}, depsPath)
+
+				Expect(subject.FDDInstallFrameworks()).To(Succeed())
+			})
+		})
+
+		Context("when the policy is not valid", func() {
+			It("returns an error", func() {
+				createRollForwardConfig("Sometimes", "true")
+
+				Expect(subject.FDDInstallFrameworks()).To(MatchError("invalid roll forward policy 'Sometimes' set by rollForward in runtimeconfig.json, must be one of Disable, LatestPatch, Minor, LatestMinor, Major, LatestMajor"))
+			})
+		})
+	})
+
+	Describe("InstallPublishedFrameworks and RemoveUnusedRuntimeFiles", func() {
+		var publishDir string
+
+		BeforeEach(func() {
+			publishDir = filepath.Join(depsDir, depsIdx, "dotnet_publish")
+			Expect(os.MkdirAll(publishDir, 0755)).To(Succeed())
+
+			content := `{ "runtimeOptions": { "framework": { "name": "Microsoft.NETCore.App", "version": "8.0.4" }, "applyPatches": false } }`
+			Expect(os.WriteFile(filepath.Join(publishDir, "app.runtimeconfig.json"), []byte(content), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(publishDir, "app.deps.json"), []byte(`{ "libraries": {} }`), 0644)).To(Succeed())
+
+			for _, dir := range []string{
+				"sdk/8.0.204",
+				"packs/Microsoft.NETCore.App.Ref",
+				"host/fxr/8.0.4",
+				"shared/Microsoft.NETCore.App/8.0.3",
+				"shared/Microsoft.NETCore.App/8.0.4",
+				"shared/Microsoft.AspNetCore.App/8.0.4",
+			} {
+				Expect(os.MkdirAll(filepath.Join(depsPath, dir), 0755)).To(Succeed())
+			}
+		})
+
+		It("installs the frameworks from the published runtimeconfig.json and removes everything else but the host", func() {
+			mockInstaller.
+				EXPECT().
+				InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.4"}, depsPath)
+
+			Expect(subject.InstallPublishedFrameworks()).To(Succeed())
+			Expect(subject.RemoveUnusedRuntimeFiles()).To(Succeed())
+
+			Expect(filepath.Join(depsPath, "shared", "Microsoft.NETCore.App", "8.0.4")).To(BeADirectory())
+			Expect(filepath.Join(depsPath, "host", "fxr", "8.0.4")).To(BeADirectory())
+			Expect(filepath.Join(depsPath, "shared", "Microsoft.NETCore.App", "8.0.3")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(depsPath, "shared", "Microsoft.AspNetCore.App")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(depsPath, "sdk")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(depsPath, "packs")).NotTo(BeAnExistingFile())
+		})
+	})
+
 	Describe("SourceInstallDotnetRuntime", func() {
 		Context("when the runtime version is specified under <TargetFramework> as 'netX.Y'", func() {
 			BeforeEach(func() {
@@ -642,7 +1011,7 @@ var _ = Describe("Project", func() {
 					EXPECT().
 					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "5.0.2"}, depsPath)
 
-				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
 			})
 		})
 
@@ -668,7 +1037,7 @@ var _ = Describe("Project", func() {
 					EXPECT().
 					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "10.0.2"}, depsPath)
 
-				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
 			})
 		})
 
@@ -694,7 +1063,7 @@ var _ = Describe("Project", func() {
 					EXPECT().
 					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.9"}, depsPath)
 
-				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
 			})
 		})
 
@@ -719,7 +1088,7 @@ var _ = Describe("Project", func() {
 					EXPECT().
 					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
 
-				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
 			})
 		})
 
@@ -746,7 +1115,447 @@ var _ = Describe("Project", func() {
 					EXPECT().
 					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.9"}, depsPath)
 
-				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+		Context("when the project is multi-targeted with <TargetFrameworks>", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"),
+					[]byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFrameworks>net8.0;net10.0</TargetFrameworks>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("installs the runtime for the given target framework", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"8.0.1", "8.0.2", "10.0.1"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "8.0.2"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.2"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("net8.0")).To(Succeed())
+			})
+
+			It("installs the runtime for the highest supported target framework when none is given", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"8.0.1", "8.0.2", "10.0.1"}).
+					Times(2)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "10.0.1"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "10.0.1"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+
+		Context("when the project does not need ASP.NET Core", func() {
+			DescribeTable("installs only the dotnet-runtime",
+				func(sdk string) {
+					Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(fmt.Sprintf(`
+<Project Sdk="%s">
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+</Project>`, sdk)), 0644)).To(Succeed())
+
+					mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"6.7.8"})
+					mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+					Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+				},
+				Entry("for a console app", "Microsoft.NET.Sdk"),
+				Entry("for a worker service", "Microsoft.NET.Sdk.Worker"),
+			)
+		})
+
+		Context("when the project needs ASP.NET Core", func() {
+			DescribeTable("installs dotnet-aspnetcore and the dotnet-runtime",
+				func(csproj string) {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "lib"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "lib", "lib.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk">
+	<ItemGroup>
+		<FrameworkReference Include="Microsoft.AspNetCore.App" />
+	</ItemGroup>
+</Project>`), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[config]\nproject = foo.csproj"), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(csproj), 0644)).To(Succeed())
+
+					mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"6.7.8"})
+					mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath)
+					mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+					Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+				},
+				Entry("for an Sdk element", `
+<Project>
+	<Sdk Name="Microsoft.NET.Sdk.Web" />
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+</Project>`),
+				Entry("for a FrameworkReference", `
+<Project Sdk="Microsoft.NET.Sdk">
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+	<ItemGroup>
+		<FrameworkReference Include="Microsoft.AspNetCore.App" />
+	</ItemGroup>
+</Project>`),
+				Entry("for a ProjectReference to a project with a FrameworkReference", `
+<Project Sdk="Microsoft.NET.Sdk">
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+	<ItemGroup>
+		<ProjectReference Include="lib\lib.csproj" />
+	</ItemGroup>
+</Project>`),
+			)
+		})
+
+		Context("when the TargetFramework is set in a Directory.Build.props file in a parent directory", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "src", "foo"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "src", "foo", "foo.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+			})
+
+			It("installs the latest runtime for that minor", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"4.5.6", "6.7.8", "6.7.9", "6.8.9"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.9"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.9"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+
+		Context("when the RuntimeFrameworkVersion is set in a file imported by Directory.Build.props", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "build"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"), []byte(`
+<Project>
+	<Import Project="build\common.props" />
+	<PropertyGroup>
+		<TargetFramework>net6.6</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "build", "common.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<RuntimeFrameworkVersion>6.7.8</RuntimeFrameworkVersion>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("installs the runtime", func() {
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+
+		Context("when the csproj overrides the TargetFramework from Directory.Build.props", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<TargetFramework>net5.0</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("installs the runtime for the csproj TargetFramework", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"5.0.1", "6.7.9"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.9"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.9"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+	})
+
+	Describe("SourceTargetFramework", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFrameworks>netstandard2.0;net8.0;net10.0;net11.0</TargetFrameworks>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+		})
+
+		Context("when no target framework is requested", func() {
+			It("returns the highest target framework with a runtime in the manifest", func() {
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"8.0.1", "10.0.2"})
+
+				targetFramework, err := subject.SourceTargetFramework("")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(targetFramework).To(Equal("net10.0"))
+			})
+
+			It("returns an error when the manifest has none of the target frameworks", func() {
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"6.0.1"})
+
+				_, err := subject.SourceTargetFramework("")
+				Expect(err).To(MatchError("none of the project's target frameworks [netstandard2.0 net8.0 net10.0 net11.0] are supported by this buildpack"))
+			})
+		})
+
+		Context("when a target framework is requested", func() {
+			It("returns the requested target framework", func() {
+				targetFramework, err := subject.SourceTargetFramework("net8.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(targetFramework).To(Equal("net8.0"))
+			})
+
+			It("returns an error when the project does not target it", func() {
+				_, err := subject.SourceTargetFramework("net9.0")
+				Expect(err).To(MatchError("target framework net9.0 is not one of the project's target frameworks [netstandard2.0 net8.0 net10.0 net11.0]"))
+			})
+		})
+
+		Context("when the project has a single TargetFramework", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFramework>net8.0</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("returns it", func() {
+				targetFramework, err := subject.SourceTargetFramework("")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(targetFramework).To(Equal("net8.0"))
+			})
+		})
+	})
+
+	Describe("evaluating project files", func() {
+		Context("when properties reference other properties and environment variables", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("APP_RUNTIME_MINOR", "7")).To(Succeed())
+				DeferCleanup(os.Unsetenv, "APP_RUNTIME_MINOR")
+
+				Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<AppTargetFramework>net6.$(APP_RUNTIME_MINOR)</AppTargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFramework>$(AppTargetFramework)</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("expands them", func() {
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"6.7.8", "6.8.9"})
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath)
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+
+		Context("when PropertyGroups and items have Conditions on the Configuration", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<AssemblyName>fred</AssemblyName>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+	<PropertyGroup Condition="'$(Configuration)|$(Platform)' == 'Release|AnyCPU' Or '$(Configuration)'=='Release'">
+		<AssemblyName>fred.release</AssemblyName>
+	</PropertyGroup>
+	<PropertyGroup Condition=" '$(Configuration)' != 'Release' And !Exists('missing.props') ">
+		<AssemblyName>fred.debug</AssemblyName>
+	</PropertyGroup>
+	<ItemGroup Condition="'$(Configuration)' == 'Release'">
+		<PackageReference Include="System.Drawing.Common" Version="4.5.1" />
+	</ItemGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+				for _, name := range []string{"fred.release", "fred.debug"} {
+					Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", name), []byte(""), 0755)).To(Succeed())
+				}
+			})
+
+			Context("and the app is published with the Debug configuration", func() {
+				It("uses the Debug properties", func() {
+					startCmd, err := subject.StartCommand()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "fred.debug")))
+
+					Expect(subject.UsesLibrary("System.Drawing.Common")).To(BeFalse())
+				})
+			})
+
+			Context("and the app is published with the Release configuration", func() {
+				BeforeEach(func() {
+					subject.SetGlobalProperty("Configuration", "Release")
+				})
+
+				It("uses the Release properties", func() {
+					startCmd, err := subject.StartCommand()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "fred.release")))
+
+					Expect(subject.UsesLibrary("System.Drawing.Common")).To(BeTrue())
+				})
+			})
+		})
+
+		Context("when an Import uses $(MSBuildThisFileDirectory) and a Condition", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "build"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "build", "runtime.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<RuntimeFrameworkVersion>6.7.8</RuntimeFrameworkVersion>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<Import Project="$(MSBuildThisFileDirectory)build/runtime.props" Condition="Exists('$(MSBuildThisFileDirectory)build/runtime.props')" />
+	<Import Project="$(MSBuildThisFileDirectory)build/other.props" Condition="'$(Configuration)' == 'Release'" />
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("follows the import", func() {
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath)
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime("")).To(Succeed())
+			})
+		})
+	})
+
+	Describe("LockFilePaths", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "app"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "lib"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "data"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "app", "app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web">
+				<ItemGroup><ProjectReference Include="..\lib\lib.csproj" /></ItemGroup>
+			</Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "lib", "lib.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk">
+				<PropertyGroup><NuGetLockFilePath>locks/lib.lock.json</NuGetLockFilePath></PropertyGroup>
+				<ItemGroup><ProjectReference Include="../data/data.csproj" /></ItemGroup>
+			</Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "data", "data.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk" />`), 0644)).To(Succeed())
+
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "app", "packages.app.lock.json"), []byte(`{}`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "lib", "locks"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "lib", "locks", "lib.lock.json"), []byte(`{}`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[config]\nproject = src/app/app.csproj"), 0644)).To(Succeed())
+		})
+
+		It("returns the lock files of the main project and its project references", func() {
+			Expect(subject.LockFilePaths()).To(Equal([]string{
+				filepath.Join(buildDir, "src", "app", "packages.app.lock.json"),
+				filepath.Join(buildDir, "src", "lib", "locks", "lib.lock.json"),
+			}))
+		})
+	})
+
+	Describe("CheckCompatibility", func() {
+		DescribeTable("accepts projects that run on Linux",
+			func(csproj string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(csproj), 0644)).To(Succeed())
+				Expect(subject.CheckCompatibility()).To(Succeed())
+			},
+			Entry("a web app", `<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`),
+			Entry("a project that also targets Windows", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net8.0;net8.0-windows</TargetFrameworks></PropertyGroup></Project>`),
+		)
+
+		DescribeTable("explains why a project cannot run on Linux",
+			func(csproj, message string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(csproj), 0644)).To(Succeed())
+
+				err := subject.CheckCompatibility()
+				Expect(err).To(BeAssignableToTypeOf(&project.IncompatibleProjectError{}))
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("an old-style .NET Framework project", `<?xml version="1.0" encoding="utf-8"?>
+				<Project ToolsVersion="15.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+					<Import Project="$(MSBuildExtensionsPath)\$(MSBuildToolsVersion)\Microsoft.Common.props" />
+					<PropertyGroup><TargetFrameworkVersion>v4.7.2</TargetFrameworkVersion></PropertyGroup>
+				</Project>`, "app.csproj targets the .NET Framework v4.7.2, which is not supported on Linux.\nMigrate the project to an SDK-style project"),
+			Entry("an SDK-style .NET Framework project", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net48</TargetFramework></PropertyGroup></Project>`,
+				"app.csproj targets the .NET Framework (net48)"),
+			Entry("a WPF app", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net8.0-windows</TargetFramework><UseWPF>true</UseWPF></PropertyGroup></Project>`,
+				"app.csproj is a WPF or Windows Forms app"),
+			Entry("a Windows Forms app using the WindowsDesktop SDK", `<Project Sdk="Microsoft.NET.Sdk.WindowsDesktop"><PropertyGroup><TargetFramework>netcoreapp3.1</TargetFramework></PropertyGroup></Project>`,
+				"app.csproj is a WPF or Windows Forms app"),
+			Entry("a MAUI app", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net8.0-android;net8.0-ios</TargetFrameworks><UseMaui>true</UseMaui></PropertyGroup></Project>`,
+				"app.csproj is a Xamarin or .NET MAUI app"),
+			Entry("a Xamarin app", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>monoandroid10.0</TargetFramework></PropertyGroup></Project>`,
+				"app.csproj is a Xamarin or .NET MAUI app"),
+			Entry("a UWP app", `<Project Sdk="MSBuild.Sdk.Extras"><PropertyGroup><TargetFramework>uap10.0.19041</TargetFramework></PropertyGroup></Project>`,
+				"app.csproj is a Universal Windows Platform (UWP) app"),
+			Entry("a Windows-only app", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net8.0-windows</TargetFramework></PropertyGroup></Project>`,
+				"app.csproj only targets Windows (net8.0-windows)"),
+		)
+
+		Context("when a published app requires the WindowsDesktop framework", func() {
+			BeforeEach(func() {
+				createRuntimeConfig("Microsoft.WindowsDesktop.App", "8.0.0")
+			})
+
+			It("explains that WPF and Windows Forms are not supported", func() {
+				Expect(subject.CheckCompatibility()).To(MatchError(ContainSubstring("test.runtimeconfig.json requires the Microsoft.WindowsDesktop.App framework for WPF and Windows Forms")))
 			})
 		})
 	})
@@ -828,3 +1637,12 @@ var _ = Describe("FindMatchingVersionWithPreview", func() {
 		Expect(ver).To(Equal("3.0.0-preview6-27720-01"))
 	})
 })
+
+// appHost returns an ELF apphost whose bundle marker holds headerOffset, which
+// is zero unless the app was published with PublishSingleFile
+func appHost(headerOffset uint64) []byte {
+	host := []byte("\x7fELF host")
+	host = binary.LittleEndian.AppendUint64(host, headerOffset)
+	return append(host, 0x8b, 0x12, 0x02, 0xb9, 0x6a, 0x61, 0x20, 0x38, 0x72, 0x7b, 0x93, 0x02, 0x14, 0xd7, 0xa0, 0x32,
+		0x13, 0xf5, 0xb9, 0xe6, 0xef, 0xae, 0x33, 0x18, 0xee, 0x3b, 0x2d, 0xce, 0x24, 0xb3, 0x6a, 0xae)
+}
@@ -0,0 +1,174 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// The roll-forward policies the dotnet host supports for framework references,
+// see https://learn.microsoft.com/dotnet/core/versions/selection
+const (
+	RollForwardDisable     = "Disable"
+	RollForwardLatestPatch = "LatestPatch"
+	RollForwardMinor       = "Minor"
+	RollForwardLatestMinor = "LatestMinor"
+	RollForwardMajor       = "Major"
+	RollForwardLatestMajor = "LatestMajor"
+)
+
+var rollForwardPolicies = []string{
+	RollForwardDisable,
+	RollForwardLatestPatch,
+	RollForwardMinor,
+	RollForwardLatestMinor,
+	RollForwardMajor,
+	RollForwardLatestMajor,
+}
+
+// rollForwardPolicy returns the policy set by rollForward in a
+// runtimeconfig.json or, like the dotnet host, by DOTNET_ROLL_FORWARD when the
+// runtimeconfig.json does not set one. It returns "" when neither is set.
+func rollForwardPolicy(runtimeConfig ConfigJSON) (string, error) {
+	if value := runtimeConfig.RuntimeOptions.RollForward; value != "" {
+		return parseRollForwardPolicy(value, "rollForward in runtimeconfig.json")
+	}
+
+	if value := os.Getenv("DOTNET_ROLL_FORWARD"); value != "" {
+		return parseRollForwardPolicy(value, "DOTNET_ROLL_FORWARD")
+	}
+
+	return "", nil
+}
+
+func parseRollForwardPolicy(value, source string) (string, error) {
+	for _, policy := range rollForwardPolicies {
+		if strings.EqualFold(value, policy) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid roll forward policy '%s' set by %s, must be one of %s", value, source, strings.Join(rollForwardPolicies, ", "))
+}
+
+// rollForwardVersion picks the version out of versions that the dotnet host
+// would run a framework reference to version on with the given policy. It
+// also returns a description of the rule that chose the version.
+func rollForwardVersion(policy, version string, applyPatches bool, versions []string) (string, string, error) {
+	requested, err := semver.ParseTolerant(version)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid framework version '%s': %v", version, err)
+	}
+
+	var candidates []semver.Version
+	for _, v := range versions {
+		candidate, err := semver.Parse(v)
+		if err != nil || candidate.LT(requested) {
+			continue
+		}
+		// Like the host, only roll forward to a prerelease when one was requested
+		if len(candidate.Pre) > 0 && len(requested.Pre) == 0 {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	notFound := fmt.Errorf("no version matching %s with roll forward policy %s in %v", version, policy, versions)
+
+	if policy == RollForwardDisable {
+		for _, candidate := range candidates {
+			if candidate.EQ(requested) {
+				return candidate.String(), "rollForward Disable: exact version", nil
+			}
+		}
+		return "", "", notFound
+	}
+
+	sameMinor := filterVersions(candidates, func(v semver.Version) bool {
+		return v.Major == requested.Major && v.Minor == requested.Minor
+	})
+	sameMajor := filterVersions(candidates, func(v semver.Version) bool {
+		return v.Major == requested.Major
+	})
+
+	switch policy {
+	case RollForwardLatestPatch:
+		if len(sameMinor) > 0 {
+			return pickPatch(policy, sameMinor, applyPatches)
+		}
+	case RollForwardMinor, RollForwardMajor:
+		if len(sameMinor) > 0 {
+			return pickPatch(policy, sameMinor, applyPatches)
+		}
+		if len(sameMajor) > 0 {
+			return pickPatch(policy, lowestMinor(sameMajor), applyPatches)
+		}
+		if policy == RollForwardMajor && len(candidates) > 0 {
+			lowest := lowestVersion(candidates)
+			return pickPatch(policy, lowestMinor(filterVersions(candidates, func(v semver.Version) bool {
+				return v.Major == lowest.Major
+			})), applyPatches)
+		}
+	case RollForwardLatestMinor:
+		if len(sameMajor) > 0 {
+			latest := highestVersion(sameMajor)
+			return latest.String(), fmt.Sprintf("rollForward %s: latest minor version of %d", policy, requested.Major), nil
+		}
+	case RollForwardLatestMajor:
+		if len(candidates) > 0 {
+			return highestVersion(candidates).String(), fmt.Sprintf("rollForward %s: latest version", policy), nil
+		}
+	}
+
+	return "", "", notFound
+}
+
+// pickPatch picks the latest patch out of versions of the same major and
+// minor version, or the lowest one when applyPatches is false
+func pickPatch(policy string, versions []semver.Version, applyPatches bool) (string, string, error) {
+	band := fmt.Sprintf("%d.%d", versions[0].Major, versions[0].Minor)
+	if !applyPatches {
+		return lowestVersion(versions).String(), fmt.Sprintf("rollForward %s with applyPatches false: lowest patch of %s", policy, band), nil
+	}
+	return highestVersion(versions).String(), fmt.Sprintf("rollForward %s: latest patch of %s", policy, band), nil
+}
+
+// lowestMinor returns the versions with the lowest minor version out of
+// versions of the same major version
+func lowestMinor(versions []semver.Version) []semver.Version {
+	lowest := lowestVersion(versions)
+	return filterVersions(versions, func(v semver.Version) bool {
+		return v.Minor == lowest.Minor
+	})
+}
+
+func filterVersions(versions []semver.Version, keep func(semver.Version) bool) []semver.Version {
+	var filtered []semver.Version
+	for _, v := range versions {
+		if keep(v) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+func lowestVersion(versions []semver.Version) semver.Version {
+	lowest := versions[0]
+	for _, v := range versions[1:] {
+		if v.LT(lowest) {
+			lowest = v
+		}
+	}
+	return lowest
+}
+
+func highestVersion(versions []semver.Version) semver.Version {
+	highest := versions[0]
+	for _, v := range versions[1:] {
+		if v.GT(highest) {
+			highest = v
+		}
+	}
+	return highest
+}
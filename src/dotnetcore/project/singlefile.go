@@ -0,0 +1,112 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// bundleSignature is the marker of the bundle header offset in the .NET
+// apphost, the SHA-256 hash of ".net core bundle". Every apphost has it, but
+// only the executable of an app published with PublishSingleFile has a
+// non-zero offset in the 8 bytes before it.
+var bundleSignature = []byte{
+	0x8b, 0x12, 0x02, 0xb9, 0x6a, 0x61, 0x20, 0x38,
+	0x72, 0x7b, 0x93, 0x02, 0x14, 0xd7, 0xa0, 0x32,
+	0x13, 0xf5, 0xb9, 0xe6, 0xef, 0xae, 0x33, 0x18,
+	0xee, 0x3b, 0x2d, 0xce, 0x24, 0xb3, 0x6a, 0xae,
+}
+
+// publishedBundle returns the single-file bundle of an app that was pushed
+// already published with PublishSingleFile, which has neither a
+// runtimeconfig.json nor project files, or "" for other apps. The app
+// directory is only searched the first time.
+func (p *Project) publishedBundle() (string, error) {
+	if p.bundleSearched {
+		return p.bundle, nil
+	}
+
+	if paths, err := p.ProjectFilePaths(); err != nil {
+		return "", err
+	} else if len(paths) == 0 {
+		bundle, err := singleFileBundle(p.buildDir)
+		if err != nil {
+			return "", err
+		}
+		p.bundle = bundle
+	}
+	p.bundleSearched = true
+	return p.bundle, nil
+}
+
+// singleFileBundle returns the executable in dir that bundles an app published
+// with PublishSingleFile, which has no .dll or runtimeconfig.json next to it,
+// or "" when there is none
+func singleFileBundle(dir string) (string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			return "", err
+		}
+		// Shared libraries are ELF files as well, but never bundles
+		if !info.Mode().IsRegular() || filepath.Ext(file.Name()) == ".so" || filepath.Ext(file.Name()) == ".dll" {
+			continue
+		}
+
+		if bundle, err := isSingleFileBundle(filepath.Join(dir, file.Name())); err != nil {
+			return "", err
+		} else if bundle {
+			return file.Name(), nil
+		}
+	}
+	return "", nil
+}
+
+func isSingleFileBundle(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	if magic, err := reader.Peek(4); err != nil || !bytes.Equal(magic, []byte("\x7fELF")) {
+		return false, nil
+	}
+
+	// Search the file a chunk at a time, carrying over enough of each chunk to
+	// find a signature and the offset before it that span two of them
+	chunk := make([]byte, 1024*1024)
+	var tail []byte
+	for {
+		n, err := reader.Read(chunk)
+		if n > 0 {
+			window := append(tail, chunk[:n]...)
+			for start := 0; ; {
+				i := bytes.Index(window[start:], bundleSignature)
+				if i < 0 {
+					break
+				}
+				i += start
+				if i >= 8 && binary.LittleEndian.Uint64(window[i-8:i]) != 0 {
+					return true, nil
+				}
+				start = i + 1
+			}
+			tail = append([]byte{}, window[max(0, len(window)-len(bundleSignature)-8+1):]...)
+		}
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
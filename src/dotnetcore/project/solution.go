@@ -0,0 +1,238 @@
+package project
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var slnProjectRE = regexp.MustCompile(`^Project\("[^"]*"\)\s*=\s*"[^"]*"\s*,\s*"([^"]+)"`)
+
+// startupProject picks the project to publish when the app contains more than
+// one project file and no .deployment file. Only the projects listed in the
+// .sln and .slnx files at the root of the app are considered, when there are
+// any, and test and class library projects are skipped.
+func (p *Project) startupProject(paths []string) (string, error) {
+	candidates, err := p.solutionProjectPaths()
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		candidates = paths
+	}
+
+	var executables []string
+	for _, path := range candidates {
+		proj, err := loadProject(path, p.buildDir, p.globalProperties)
+		if err != nil {
+			return "", fmt.Errorf("could not parse project file %s: %v", p.relativePath(path), err)
+		}
+
+		if proj.isExecutable() {
+			executables = append(executables, path)
+		}
+	}
+
+	if len(executables) == 1 {
+		if executables[0] != p.startupProjectPath {
+			p.startupProjectPath = executables[0]
+			p.Log.Debug("Using startup project %s", p.relativePath(executables[0]))
+		}
+		return executables[0], nil
+	}
+
+	var names []string
+	for _, path := range candidates {
+		names = append(names, p.relativePath(path))
+	}
+
+	if len(executables) == 0 {
+		return "", fmt.Errorf("multiple paths: %v contain a project file, but none of them is an executable or web project and no .deployment file was used", names)
+	}
+
+	names = nil
+	for _, path := range executables {
+		names = append(names, p.relativePath(path))
+	}
+	return "", fmt.Errorf("multiple executable or web projects found: %s; add a .deployment file to the root of the app to choose one, for example:\n[config]\nproject = %s", strings.Join(names, ", "), names[0])
+}
+
+// solutionProjectPaths returns the project files listed in the .sln and .slnx
+// files at the root of the app that exist on disk
+func (p *Project) solutionProjectPaths() ([]string, error) {
+	var solutions []string
+	for _, pattern := range []string{"*.sln", "*.slnx"} {
+		matches, err := filepath.Glob(filepath.Join(p.buildDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		solutions = append(solutions, matches...)
+	}
+
+	found := map[string]bool{}
+	var paths []string
+	for _, solution := range solutions {
+		var projects []string
+		var err error
+		if strings.HasSuffix(solution, ".slnx") {
+			projects, err = parseSlnx(solution)
+		} else {
+			projects, err = parseSln(solution)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse solution file %s: %v", filepath.Base(solution), err)
+		}
+
+		for _, project := range projects {
+			if !isProjectFile(project) {
+				continue
+			}
+
+			path := filepath.Join(filepath.Dir(solution), filepath.FromSlash(strings.ReplaceAll(project, `\`, "/")))
+			if info, err := os.Stat(path); err != nil || info.IsDir() || found[path] {
+				continue
+			}
+			found[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (p *Project) relativePath(path string) string {
+	if rel, err := filepath.Rel(p.buildDir, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+func parseSln(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var projects []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if matches := slnProjectRE.FindStringSubmatch(strings.TrimSpace(scanner.Text())); len(matches) == 2 {
+			projects = append(projects, matches[1])
+		}
+	}
+	return projects, scanner.Err()
+}
+
+func parseSlnx(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var projects []string
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return projects, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "Project" {
+			for _, attr := range element.Attr {
+				if attr.Name.Local == "Path" {
+					projects = append(projects, attr.Value)
+				}
+			}
+		}
+	}
+}
+
+func isProjectFile(path string) bool {
+	return strings.HasSuffix(path, ".csproj") || strings.HasSuffix(path, ".vbproj") || strings.HasSuffix(path, ".fsproj")
+}
+
+// TestProjectPaths returns the test projects among the app's project files,
+// which set IsTestProject, use MSTest.Sdk or reference Microsoft.NET.Test.Sdk
+func (p *Project) TestProjectPaths() ([]string, error) {
+	paths, err := p.ProjectFilePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var testPaths []string
+	for _, path := range paths {
+		proj, err := loadProject(path, p.buildDir, p.globalProperties)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse project file %s: %v", p.relativePath(path), err)
+		}
+		if proj.isTestProject() {
+			testPaths = append(testPaths, path)
+		}
+	}
+	return testPaths, nil
+}
+
+func (proj CSProj) isTestProject() bool {
+	if strings.EqualFold(proj.PropertyGroup.IsTestProject, "true") {
+		return true
+	}
+
+	for _, sdk := range proj.sdks() {
+		if sdk == "MSTest.Sdk" {
+			return true
+		}
+	}
+
+	for _, ig := range proj.ItemGroups {
+		for _, pr := range ig.PackageReferences {
+			if pr.Include == "Microsoft.NET.Test.Sdk" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isExecutable reports whether a project builds an app rather than a test or
+// class library project. Projects without an OutputType get the default of
+// their SDK, which is Exe for web and worker projects and Library otherwise.
+func (proj CSProj) isExecutable() bool {
+	if proj.isTestProject() {
+		return false
+	}
+
+	switch strings.ToLower(proj.PropertyGroup.OutputType) {
+	case "exe", "winexe":
+		return true
+	case "":
+		for _, sdk := range proj.sdks() {
+			switch sdk {
+			case "Microsoft.NET.Sdk.Web", "Microsoft.NET.Sdk.Worker", "Microsoft.NET.Sdk.BlazorWebAssembly":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sdks returns the names of the MSBuild SDKs a project uses, without versions
+func (proj CSProj) sdks() []string {
+	var sdks []string
+	for _, sdk := range strings.Split(proj.Sdk, ";") {
+		if name := strings.TrimSpace(strings.SplitN(sdk, "/", 2)[0]); name != "" {
+			sdks = append(sdks, name)
+		}
+	}
+	return sdks
+}
@@ -0,0 +1,53 @@
+package project
+
+import (
+	"sort"
+	"strings"
+)
+
+// reflectionHeavyPackages are popular packages, along with the packages named
+// after them, that load types by reflection and are known to break when an
+// app is trimmed
+var reflectionHeavyPackages = []string{
+	"AutoMapper",
+	"Autofac",
+	"Castle.Core",
+	"Dapper",
+	"MediatR",
+	"Microsoft.EntityFrameworkCore",
+	"NHibernate",
+	"Newtonsoft.Json",
+	"Serilog.Settings.Configuration",
+	"Swashbuckle.AspNetCore",
+}
+
+// ReflectionHeavyPackages returns the packages referenced by the app's
+// projects that are known to break when the app is trimmed
+func (p *Project) ReflectionHeavyPackages() ([]string, error) {
+	references, err := p.PackageReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	var packages []string
+	for _, ref := range references {
+		if found[strings.ToLower(ref.Include)] || !isReflectionHeavy(ref.Include) {
+			continue
+		}
+		found[strings.ToLower(ref.Include)] = true
+		packages = append(packages, ref.Include)
+	}
+
+	sort.Strings(packages)
+	return packages, nil
+}
+
+func isReflectionHeavy(name string) bool {
+	for _, pkg := range reflectionHeavyPackages {
+		if strings.EqualFold(name, pkg) || strings.HasPrefix(strings.ToLower(name), strings.ToLower(pkg)+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
 	_ "github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/hooks"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/supply"
 
@@ -44,6 +45,7 @@ func main() {
 		logger.Error("Unable to apply override.yml files: %s", err)
 		os.Exit(17)
 	}
+	platform.FilterManifest(manifest, platform.Arch())
 
 	err = libbuildpack.RunBeforeCompile(stager)
 	if err != nil {
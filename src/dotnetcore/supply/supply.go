@@ -3,15 +3,20 @@ package supply
 import (
 	"crypto/md5"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/blang/semver"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -22,6 +27,16 @@ type Command interface {
 	Output(string, string, ...string) (string, error)
 }
 
+var (
+	msbuildNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	processNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	projectFileRE = regexp.MustCompile(`\.[a-z]+proj$`)
+	icuLibraryRE  = regexp.MustCompile(`libicuuc\.so\.(\d+)`)
+)
+
+// icuMinimumVersion is the oldest ICU the .NET runtime loads
+const icuMinimumVersion = 50
+
 type Manifest interface {
 	AllDependencyVersions(string) []string
 	DefaultVersion(string) (libbuildpack.Dependency, error)
@@ -67,6 +82,21 @@ func Run(s *Supplier) error {
 		s.Log.Debug("BuildDir Checksum Before Supply: %s", checksum)
 	}
 
+	if err := s.CheckPlatform(); err != nil {
+		s.Log.Error("Unsupported platform: %s", err.Error())
+		return err
+	}
+
+	if err := s.ReadStartSettings(); err != nil {
+		s.Log.Error("Invalid start settings in buildpack.yml: %s", err.Error())
+		return err
+	}
+
+	if err := s.Project.CheckCompatibility(); err != nil {
+		s.Log.Error("Unsupported project: %s", err.Error())
+		return err
+	}
+
 	if err := s.InstallLibunwind(); err != nil {
 		s.Log.Error("Unable to install Libunwind: %s", err.Error())
 		return err
@@ -85,6 +115,46 @@ func Run(s *Supplier) error {
 		}
 	}
 
+	if err := s.ReadPublishSettings(); err != nil {
+		s.Log.Error("Invalid publish settings in buildpack.yml: %s", err.Error())
+		return err
+	}
+
+	if err := s.ReadTestSettings(); err != nil {
+		s.Log.Error("Invalid test settings in buildpack.yml: %s", err.Error())
+		return err
+	}
+
+	if err := s.ReadProcesses(); err != nil {
+		s.Log.Error("Invalid processes in buildpack.yml: %s", err.Error())
+		return err
+	}
+
+	if err := s.ReadMigrationsSettings(); err != nil {
+		s.Log.Error("Invalid migrations settings in buildpack.yml: %s", err.Error())
+		return err
+	}
+
+	if err := s.SelectTargetFramework(); err != nil {
+		s.Log.Error("Unable to select a target framework: %s", err.Error())
+		return err
+	}
+
+	if err := s.SelectPublishMode(); err != nil {
+		s.Log.Error("Unable to select a publish mode: %s", err.Error())
+		return err
+	}
+
+	if err := s.CheckNativeAot(); err != nil {
+		s.Log.Error("Unable to publish with Native AOT: %s", err.Error())
+		return err
+	}
+
+	if err := s.SelectGlobalizationMode(); err != nil {
+		s.Log.Error("Unable to select a globalization mode: %s", err.Error())
+		return err
+	}
+
 	if err := s.InstallDotnetSdk(); err != nil {
 		s.Log.Error("Unable to install Dotnet SDK: %s", err.Error())
 		return err
@@ -290,55 +360,114 @@ func (s *Supplier) commandsInProjFiles(commands []string) (bool, error) {
 
 }
 
-// Turn a semver string into major.minor.x
-// Will turn a.b.c into a.b.x
-// Will not modify strings that don't match a.b.c
-func sdkRollForward(version string, versions []string) (string, error) {
-	// Filter versions that match the major.minor version
-	versions = filterVersions(versions, version)
-
-	var featureLine string
-	var highestPatch string
-	parts := strings.SplitN(version, ".", 3)
-	if len(parts) == 3 {
-		featureLine = parts[2][:1]
+// sdkRollForward picks the SDK the dotnet host would select for the version,
+// rollForward policy and allowPrerelease flag of a global.json file. See
+// https://learn.microsoft.com/en-us/dotnet/core/tools/global-json#rollforward
+func sdkRollForward(version, policy string, allowPrerelease bool, versions []string) (string, error) {
+	var requested semver.Version
+	if version != "" {
+		var err error
+		if requested, err = semver.Parse(version); err != nil {
+			return "", fmt.Errorf("invalid sdk version '%s' in global.json: %v", version, err)
+		}
+		// A prerelease version in global.json always allows prerelease SDKs
+		if len(requested.Pre) > 0 {
+			allowPrerelease = true
+		}
 	}
 
+	var candidates []semver.Version
 	for _, v := range versions {
-		versionSplit := strings.SplitN(v, ".", 3)
-		if len(versionSplit) == 3 && versionSplit[2][:1] == featureLine {
-			if highestPatch == "" {
-				highestPatch = versionSplit[2][1:]
-			} else {
-				current, err := strconv.Atoi(highestPatch)
-				comp, err := strconv.Atoi(versionSplit[2][1:])
-				if err != nil {
-					return "", err
-				}
-				if current < comp {
-					highestPatch = versionSplit[2][1:]
-				}
-			}
+		candidate, err := semver.Parse(v)
+		if err != nil || candidate.LT(requested) {
+			continue
+		}
+		if !allowPrerelease && len(candidate.Pre) > 0 {
+			continue
 		}
+		candidates = append(candidates, candidate)
 	}
 
-	if highestPatch == "" {
-		return "", fmt.Errorf("could not find sdk in same feature line as '%s'", version)
+	sameBand := func(v semver.Version) bool {
+		return v.Major == requested.Major && v.Minor == requested.Minor && featureBand(v) == featureBand(requested)
 	}
+	sameMinor := func(v semver.Version) bool { return v.Major == requested.Major && v.Minor == requested.Minor }
+	sameMajor := func(v semver.Version) bool { return v.Major == requested.Major }
+	anyVersion := func(semver.Version) bool { return true }
+
+	var match semver.Version
+	var found bool
+	switch strings.ToLower(policy) {
+	case "disable":
+		match, found = latestSdk(candidates, func(v semver.Version) bool { return v.EQ(requested) })
+	case "patch":
+		if match, found = latestSdk(candidates, func(v semver.Version) bool { return v.EQ(requested) }); !found {
+			match, found = latestSdk(candidates, sameBand)
+		}
+	case "latestpatch":
+		match, found = latestSdk(candidates, sameBand)
+	case "feature":
+		match, found = nearestSdk(candidates, sameMinor)
+	case "minor":
+		match, found = nearestSdk(candidates, sameMajor)
+	case "major":
+		match, found = nearestSdk(candidates, anyVersion)
+	case "latestfeature":
+		match, found = latestSdk(candidates, sameMinor)
+	case "latestminor":
+		match, found = latestSdk(candidates, sameMajor)
+	case "latestmajor":
+		match, found = latestSdk(candidates, anyVersion)
+	default:
+		return "", fmt.Errorf("invalid rollForward policy '%s' in global.json", policy)
+	}
+
+	if !found {
+		return "", fmt.Errorf("could not find sdk matching '%s' with rollForward policy '%s' (allowPrerelease: %t) in %v", version, policy, allowPrerelease, versions)
+	}
+
+	return match.String(), nil
+}
+
+// featureBand returns the hundreds digit of an SDK patch version, e.g. 3 for 8.0.303
+func featureBand(v semver.Version) uint64 {
+	return v.Patch / 100
+}
 
-	return fmt.Sprintf("%s.%s.%s%s", parts[0], parts[1], featureLine, highestPatch), nil
+// latestSdk returns the highest of the versions accepted by match
+func latestSdk(versions []semver.Version, match func(semver.Version) bool) (semver.Version, bool) {
+	var latest semver.Version
+	var found bool
+	for _, v := range versions {
+		if match(v) && (!found || v.GT(latest)) {
+			latest, found = v, true
+		}
+	}
+	return latest, found
 }
 
-func filterVersions(versions []string, version string) []string {
-	var filtered []string
-	semver := strings.SplitN(version, ".", 3)
-	major, minor := semver[0], semver[1]
+// nearestSdk returns the latest patch of the lowest major, minor and feature
+// band accepted by match
+func nearestSdk(versions []semver.Version, match func(semver.Version) bool) (semver.Version, bool) {
+	var nearest semver.Version
+	var found bool
 	for _, v := range versions {
-		if strings.HasPrefix(v, major+"."+minor) {
-			filtered = append(filtered, v)
+		if !match(v) {
+			continue
 		}
+		if !found || v.Major < nearest.Major ||
+			(v.Major == nearest.Major && v.Minor < nearest.Minor) ||
+			(v.Major == nearest.Major && v.Minor == nearest.Minor && featureBand(v) < featureBand(nearest)) {
+			nearest, found = v, true
+		}
+	}
+	if !found {
+		return nearest, false
 	}
-	return filtered
+
+	return latestSdk(versions, func(v semver.Version) bool {
+		return match(v) && v.Major == nearest.Major && v.Minor == nearest.Minor && featureBand(v) == featureBand(nearest)
+	})
 }
 
 func (s *Supplier) pickVersionToInstall() (string, error) {
@@ -357,22 +486,23 @@ func (s *Supplier) pickVersionToInstall() (string, error) {
 		return version, err
 	}
 
-	globalJSONVersion, err := s.globalJsonSdkVersion()
+	globalJSON, err := s.globalJsonSdk()
 	if err != nil {
 		return "", err
 	}
 
-	if globalJSONVersion != "" {
-		if contains(allVersions, globalJSONVersion) {
-			return globalJSONVersion, nil
+	policy := globalJSON.rollForwardPolicy()
+	if globalJSON.Sdk.Version != "" || strings.EqualFold(policy, "latestMajor") {
+		if globalJSON.Sdk.Version != "" && !contains(allVersions, globalJSON.Sdk.Version) {
+			s.Log.Warning("SDK %s in global.json is not available", globalJSON.Sdk.Version)
 		}
-		s.Log.Warning("SDK %s in global.json is not available", globalJSONVersion)
-		installVersion, err := sdkRollForward(globalJSONVersion, allVersions)
-		if err == nil {
-			s.Log.Info("falling back to latest version in version line")
-			return installVersion, nil
+
+		installVersion, err := sdkRollForward(globalJSON.Sdk.Version, policy, globalJSON.allowPrerelease(), allVersions)
+		if err != nil {
+			return "", err
 		}
-		return "", err
+		s.Log.Info("using SDK %s (global.json rollForward policy: %s)", installVersion, policy)
+		return installVersion, nil
 	}
 
 	dep, err := s.Manifest.DefaultVersion("dotnet-sdk")
@@ -401,6 +531,468 @@ func (s *Supplier) InstallDotnetSdk() error {
 	return s.installRuntimeIfNeeded()
 }
 
+// CheckPlatform fails staging before anything is downloaded when the
+// buildpack has no SDK or runtime for the stack and CPU architecture of the
+// cell. The manifest only holds the dependencies built for the architecture.
+func (s *Supplier) CheckPlatform() error {
+	stack := os.Getenv("CF_STACK")
+	arch := platform.Arch()
+
+	if _, err := platform.RuntimeIdentifier(stack, arch); err != nil {
+		return err
+	}
+
+	for _, dependency := range []string{"dotnet-sdk", "dotnet-runtime"} {
+		if len(s.Manifest.AllDependencyVersions(dependency)) == 0 {
+			return fmt.Errorf("this buildpack has no %s for stack %s on %s", dependency, stack, arch)
+		}
+	}
+
+	s.Log.Debug("Staging for stack %s on %s", stack, arch)
+	return nil
+}
+
+// ReadPublishSettings validates the publish section of buildpack.yml, which
+// configures the dotnet publish command finalize runs for source-based apps:
+//
+//	dotnet-core:
+//	  publish:
+//	    configuration: Release
+//	    framework: net8.0
+//	    runtime: linux-x64
+//	    properties:
+//	      InvariantGlobalization: true
+//	    profile: FolderProfile
+//	    verbosity: minimal
+//	    locked-mode: true
+//	    ready-to-run: true
+//	    trimmed: true
+//	    single-file: true
+func (s *Supplier) ReadPublishSettings() error {
+	if isSourceBased, err := s.Project.IsSourceBased(); err != nil {
+		return err
+	} else if !isSourceBased {
+		return nil
+	}
+
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+	publish := bpYaml.DotnetCore.Publish
+
+	if publish.Framework != "" && bpYaml.DotnetCore.Framework != "" && publish.Framework != bpYaml.DotnetCore.Framework {
+		return fmt.Errorf("dotnet-core.framework %s and dotnet-core.publish.framework %s differ, set only one of them", bpYaml.DotnetCore.Framework, publish.Framework)
+	}
+
+	if publish.Configuration != "" && !msbuildNameRE.MatchString(publish.Configuration) {
+		return fmt.Errorf("invalid configuration '%s'", publish.Configuration)
+	}
+
+	if publish.Runtime != "" {
+		arch := platform.Arch()
+		if !strings.HasPrefix(publish.Runtime, "linux") || !strings.HasSuffix(publish.Runtime, "-"+arch) {
+			return fmt.Errorf("runtime identifier '%s' does not run on this stack, use a linux runtime identifier for %s such as linux-%s", publish.Runtime, arch, arch)
+		}
+	}
+
+	for name, value := range publish.Properties {
+		if !msbuildNameRE.MatchString(name) {
+			return fmt.Errorf("invalid MSBuild property name '%s'", name)
+		}
+		switch strings.ToLower(name) {
+		case "configuration", "targetframework", "runtimeidentifier", "publishprofile", "publishreadytorun", "publishtrimmed", "publishsinglefile":
+			return fmt.Errorf("set the %s property with its own setting in the publish section instead of properties", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("the value of MSBuild property %s must be a single line", name)
+		}
+	}
+
+	if publish.Profile != "" {
+		mainPath, err := s.Project.MainPath()
+		if err != nil {
+			return err
+		}
+
+		profilePath := filepath.Join(filepath.Dir(mainPath), "Properties", "PublishProfiles", publish.Profile+".pubxml")
+		if exists, err := libbuildpack.FileExists(profilePath); err != nil {
+			return err
+		} else if !exists {
+			relPath, _ := filepath.Rel(s.Stager.BuildDir(), profilePath)
+			return fmt.Errorf("publish profile %s not found at %s", publish.Profile, relPath)
+		}
+	}
+
+	if publish.Verbosity != "" {
+		switch strings.ToLower(publish.Verbosity) {
+		case "q", "quiet", "m", "minimal", "n", "normal", "d", "detailed", "diag", "diagnostic":
+		default:
+			return fmt.Errorf("invalid verbosity '%s', must be one of quiet, minimal, normal, detailed or diagnostic", publish.Verbosity)
+		}
+	}
+
+	s.Config.Publish = config.Publish{
+		Configuration:     publish.Configuration,
+		RuntimeIdentifier: publish.Runtime,
+		Properties:        publish.Properties,
+		Profile:           publish.Profile,
+		Verbosity:         publish.Verbosity,
+		LockedMode:        publish.LockedMode,
+		ReadyToRun:        publish.ReadyToRun,
+		Trimmed:           publish.Trimmed,
+		SingleFile:        publish.SingleFile,
+	}
+
+	if publish.Configuration != "" {
+		s.Project.SetGlobalProperty("Configuration", publish.Configuration)
+	}
+	for name, value := range publish.Properties {
+		s.Project.SetGlobalProperty(name, value)
+	}
+	for name, value := range s.Config.Publish.OptionProperties() {
+		s.Project.SetGlobalProperty(name, value)
+	}
+
+	if publish.Trimmed {
+		packages, err := s.Project.ReflectionHeavyPackages()
+		if err != nil {
+			return err
+		}
+		if len(packages) > 0 {
+			s.Log.Warning("Trimming is enabled, but the app references packages that rely on reflection and may break when trimmed: %s. Check the trim warnings of dotnet publish and test the trimmed app.", strings.Join(packages, ", "))
+		}
+	}
+	return nil
+}
+
+// ReadTestSettings reads the test section of buildpack.yml, with which
+// source-based apps opt in to running their tests before they are published,
+// optionally only the ones matching a dotnet test filter:
+//
+//	dotnet-core:
+//	  test:
+//	    enabled: true
+//	    filter: Category!=Integration
+func (s *Supplier) ReadTestSettings() error {
+	if isSourceBased, err := s.Project.IsSourceBased(); err != nil {
+		return err
+	} else if !isSourceBased {
+		return nil
+	}
+
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+	test := bpYaml.DotnetCore.Test
+
+	if strings.ContainsAny(test.Filter, "\r\n") {
+		return errors.New("the test filter must be a single line")
+	}
+
+	if test.Enabled {
+		s.Log.Info("Running tests before publishing the app")
+	}
+	s.Config.Test = config.Test{Enabled: test.Enabled, Filter: test.Filter}
+	return nil
+}
+
+// ReadStartSettings reads the start section of buildpack.yml, which sets the
+// assembly that starts the app, the arguments it is started with and the
+// directory in the published app it is started from:
+//
+//	dotnet-core:
+//	  start:
+//	    assembly: Fred.Api
+//	    args: --urls http://0.0.0.0:${PORT}
+//	    working-dir: wwwroot
+//
+// It runs before anything looks at the app's runtimeconfig.json files, since
+// the assembly picks one when a published app has several.
+func (s *Supplier) ReadStartSettings() error {
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+	start := bpYaml.DotnetCore.Start
+
+	start.Assembly = strings.TrimSuffix(start.Assembly, ".dll")
+	if start.Assembly != "" && (!msbuildNameRE.MatchString(start.Assembly) || strings.HasSuffix(start.Assembly, ".")) {
+		return fmt.Errorf("invalid start assembly '%s', set the name of the assembly without a directory, such as Fred.Api", bpYaml.DotnetCore.Start.Assembly)
+	}
+
+	if strings.ContainsAny(start.Args, "\r\n") {
+		return errors.New("start args must be on a single line")
+	}
+
+	if start.WorkingDir != "" {
+		start.WorkingDir = filepath.Clean(start.WorkingDir)
+		if filepath.IsAbs(start.WorkingDir) || start.WorkingDir == ".." || strings.HasPrefix(start.WorkingDir, "../") {
+			return fmt.Errorf("start working-dir %s must be a directory in the published app", bpYaml.DotnetCore.Start.WorkingDir)
+		}
+		if start.WorkingDir == "." {
+			start.WorkingDir = ""
+		}
+	}
+
+	if start.Assembly != "" {
+		s.Log.Info("Starting the app with assembly %s", start.Assembly)
+		s.Project.SetEntryAssembly(start.Assembly)
+	}
+
+	s.Config.Start = config.Start{
+		Assembly:   start.Assembly,
+		Args:       start.Args,
+		WorkingDir: start.WorkingDir,
+	}
+	return nil
+}
+
+// ReadProcesses validates the processes section of buildpack.yml, which adds
+// process types that run other projects of a source-based app, such as a
+// background worker next to a web API:
+//
+//	dotnet-core:
+//	  processes:
+//	    worker: src/Worker/Worker.csproj
+func (s *Supplier) ReadProcesses() error {
+	if isSourceBased, err := s.Project.IsSourceBased(); err != nil {
+		return err
+	} else if !isSourceBased {
+		return nil
+	}
+
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range bpYaml.DotnetCore.Processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	processes := map[string]string{}
+	for _, name := range names {
+		if err := project.CheckProcessName(name); err != nil {
+			return err
+		}
+		projectPath := bpYaml.DotnetCore.Processes[name]
+
+		projectPath, err := s.Project.ProcessProjectPath(name, projectPath)
+		if err != nil {
+			return err
+		}
+
+		s.Log.Info("Adding process %s for %s", name, projectPath)
+		processes[name] = projectPath
+	}
+
+	s.Config.Processes = processes
+	return nil
+}
+
+// ReadMigrationsSettings reads the migrations section of buildpack.yml, with
+// which apps that reference Microsoft.EntityFrameworkCore.Design opt in to an
+// Entity Framework migrations bundle they can run as a task. The project with
+// the migrations defaults to the main project, and the process type to
+// migrate:
+//
+//	dotnet-core:
+//	  migrations:
+//	    enabled: true
+//	    process: migrate
+//	    project: src/Data/Data.csproj
+//	    context: AppDbContext
+func (s *Supplier) ReadMigrationsSettings() error {
+	if isSourceBased, err := s.Project.IsSourceBased(); err != nil {
+		return err
+	} else if !isSourceBased {
+		return nil
+	}
+
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+	migrations := bpYaml.DotnetCore.Migrations
+	if !migrations.Enabled {
+		return nil
+	}
+
+	if usesDesign, err := s.Project.UsesLibrary("Microsoft.EntityFrameworkCore.Design"); err != nil {
+		return err
+	} else if !usesDesign {
+		return errors.New("the migrations bundle needs a reference to Microsoft.EntityFrameworkCore.Design in the main project")
+	}
+
+	if migrations.Process == "" {
+		migrations.Process = "migrate"
+	}
+	if !processNameRE.MatchString(migrations.Process) || migrations.Process == "web" {
+		return fmt.Errorf("invalid process type '%s' for the migrations bundle", migrations.Process)
+	} else if _, ok := s.Config.Processes[migrations.Process]; ok {
+		return fmt.Errorf("process %s is also set in processes", migrations.Process)
+	}
+
+	if migrations.Project != "" {
+		migrations.Project = filepath.Clean(migrations.Project)
+		if filepath.IsAbs(migrations.Project) || strings.HasPrefix(migrations.Project, "..") || !projectFileRE.MatchString(migrations.Project) {
+			return fmt.Errorf("migrations project %s must be a project file in the app, such as src/Data/Data.csproj", migrations.Project)
+		}
+
+		if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), migrations.Project)); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf("migrations project %s not found", migrations.Project)
+		}
+	}
+
+	if migrations.Context != "" && !msbuildNameRE.MatchString(migrations.Context) {
+		return fmt.Errorf("invalid DbContext name '%s'", migrations.Context)
+	}
+
+	s.Log.Info("Building an Entity Framework migrations bundle as process %s", migrations.Process)
+	s.Config.Migrations = config.Migrations{
+		Enabled: true,
+		Process: migrations.Process,
+		Project: migrations.Project,
+		Context: migrations.Context,
+	}
+	return nil
+}
+
+// SelectTargetFramework picks the target framework that finalize installs a
+// runtime for and publishes a source-based app with. Multi-targeted projects
+// can choose one of their TargetFrameworks with dotnet-core.framework in
+// buildpack.yml.
+func (s *Supplier) SelectTargetFramework() error {
+	if isSourceBased, err := s.Project.IsSourceBased(); err != nil {
+		return err
+	} else if !isSourceBased {
+		return nil
+	}
+
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+
+	targetFramework, err := s.Project.SourceTargetFramework(bpYaml.targetFramework())
+	if err != nil {
+		return err
+	}
+
+	if targetFramework != "" {
+		s.Log.Info("Using target framework %s", targetFramework)
+		s.Project.SetGlobalProperty("TargetFramework", targetFramework)
+	}
+	s.Config.TargetFramework = targetFramework
+	return nil
+}
+
+// SelectPublishMode decides whether finalize publishes a source-based app
+// framework-dependent, so that it runs on a shared runtime instead of
+// carrying its own. Apps opt in with PUBLISH_FRAMEWORK_DEPENDENT=true or
+// dotnet-core.framework-dependent in buildpack.yml.
+func (s *Supplier) SelectPublishMode() error {
+	if isSourceBased, err := s.Project.IsSourceBased(); err != nil {
+		return err
+	} else if !isSourceBased {
+		return nil
+	}
+
+	bpYaml, err := s.parseBuildpackYamlFile()
+	if err != nil {
+		return err
+	}
+
+	frameworkDependent := bpYaml.DotnetCore.FrameworkDependent
+	if value, ok := os.LookupEnv("PUBLISH_FRAMEWORK_DEPENDENT"); ok {
+		frameworkDependent, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value '%s' for PUBLISH_FRAMEWORK_DEPENDENT", value)
+		}
+	}
+
+	if frameworkDependent {
+		switch {
+		case s.Config.Publish.Trimmed:
+			return errors.New("trimmed apps must be self-contained, turn off trimmed or framework-dependent publishing")
+		case s.Config.Publish.SingleFile:
+			return errors.New("framework-dependent single-file apps are not supported, because single-file publishing bundles the *.runtimeconfig.json the buildpack reads the frameworks to install from, turn off single-file or framework-dependent publishing")
+		}
+		s.Log.Info("Publishing app as framework-dependent")
+	}
+	s.Config.FrameworkDependent = frameworkDependent
+	return nil
+}
+
+// CheckNativeAot makes sure that the stack can build source-based apps that
+// set PublishAot, which the native linker step of dotnet publish needs clang
+// and the zlib headers for
+func (s *Supplier) CheckNativeAot() error {
+	isNativeAot, err := s.Project.IsNativeAot()
+	if err != nil || !isNativeAot {
+		return err
+	}
+
+	if s.Config.FrameworkDependent {
+		return errors.New("Native AOT apps must be self-contained, turn off PublishAot or framework-dependent publishing")
+	}
+
+	stack := os.Getenv("CF_STACK")
+	if err := s.Command.Execute(s.Stager.BuildDir(), io.Discard, io.Discard, "clang", "--version"); err != nil {
+		return fmt.Errorf("Native AOT needs clang, which is not installed on stack %s", stack)
+	}
+
+	if err := s.Command.Execute(s.Stager.BuildDir(), io.Discard, io.Discard, "sh", "-c", "echo '#include <zlib.h>' | clang -E -x c - > /dev/null"); err != nil {
+		return fmt.Errorf("Native AOT needs the zlib headers (zlib.h), which are not installed on stack %s", stack)
+	}
+
+	s.Log.Info("Publishing app with Native AOT")
+	s.Config.NativeAot = true
+	return nil
+}
+
+// SelectGlobalizationMode makes sure the app finds ICU when it starts, since
+// the runtime fails to start apps without globalization-invariant mode when
+// the stack has no ICU it can load. Apps use the stack's ICU, or run in
+// globalization-invariant mode when there is none.
+func (s *Supplier) SelectGlobalizationMode() error {
+	if invariant, err := s.Project.InvariantGlobalization(); err != nil {
+		return err
+	} else if invariant {
+		s.Log.Info("App runs in globalization-invariant mode and does not need ICU")
+		return nil
+	}
+
+	stack := os.Getenv("CF_STACK")
+	output, err := s.Command.Output(s.Stager.BuildDir(), "sh", "-c", "ldconfig -p 2>/dev/null || /sbin/ldconfig -p")
+	if err != nil {
+		s.Log.Warning("Unable to look for ICU on stack %s, assuming the app can load it: %s", stack, err.Error())
+		return nil
+	}
+
+	icuVersion := 0
+	for _, match := range icuLibraryRE.FindAllStringSubmatch(output, -1) {
+		if version, err := strconv.Atoi(match[1]); err == nil && version > icuVersion {
+			icuVersion = version
+		}
+	}
+
+	if icuVersion >= icuMinimumVersion {
+		s.Log.Info("Using ICU %d of stack %s", icuVersion, stack)
+		return nil
+	}
+
+	s.Log.Warning("Stack %s has no ICU the .NET runtime can load, so the app runs in globalization-invariant mode, "+
+		"where cultures behave like the invariant culture", stack)
+	return s.Stager.WriteProfileD("dotnet-globalization.sh", `export DOTNET_SYSTEM_GLOBALIZATION_INVARIANT="${DOTNET_SYSTEM_GLOBALIZATION_INVARIANT:-true}"`+"\n")
+}
+
 // Users can load the legacy SSL provider via:
 // - the BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER=true environment variable
 // - provide an openssl.cnf file in the application directory
@@ -475,20 +1067,37 @@ func (s *Supplier) installRuntimeIfNeeded() error {
 	return nil
 }
 
-func (s *Supplier) globalJsonSdkVersion() (string, error) {
+type globalJSON struct {
+	Sdk struct {
+		Version         string `json:"version"`
+		RollForward     string `json:"rollForward"`
+		AllowPrerelease *bool  `json:"allowPrerelease"`
+	} `json:"sdk"`
+}
+
+// rollForwardPolicy defaults to latestPatch when a version is given, the same
+// as the dotnet host
+func (g globalJSON) rollForwardPolicy() string {
+	if g.Sdk.RollForward != "" {
+		return g.Sdk.RollForward
+	}
+	return "latestPatch"
+}
+
+func (g globalJSON) allowPrerelease() bool {
+	return g.Sdk.AllowPrerelease == nil || *g.Sdk.AllowPrerelease
+}
+
+func (s *Supplier) globalJsonSdk() (globalJSON, error) {
+	obj := globalJSON{}
 	if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "global.json")); err != nil || !found {
-		return "", err
+		return obj, err
 	}
 
-	obj := struct {
-		Sdk struct {
-			Version string `json:"version"`
-		} `json:"sdk"`
-	}{}
 	if err := libbuildpack.NewJSON().Load(filepath.Join(s.Stager.BuildDir(), "global.json"), &obj); err != nil {
-		return "", err
+		return obj, err
 	}
-	return obj.Sdk.Version, nil
+	return obj, nil
 }
 
 func (s *Supplier) CalcChecksum() (string, error) {
@@ -533,10 +1142,47 @@ func contains(s []string, e string) bool {
 
 type buildpackYaml struct {
 	DotnetCore struct {
-		Version string `yaml:"sdk"`
+		Version            string `yaml:"sdk"`
+		Framework          string `yaml:"framework"`
+		FrameworkDependent bool   `yaml:"framework-dependent"`
+		Publish            struct {
+			Configuration string            `yaml:"configuration"`
+			Framework     string            `yaml:"framework"`
+			Runtime       string            `yaml:"runtime"`
+			Properties    map[string]string `yaml:"properties"`
+			Profile       string            `yaml:"profile"`
+			Verbosity     string            `yaml:"verbosity"`
+			LockedMode    *bool             `yaml:"locked-mode"`
+			ReadyToRun    bool              `yaml:"ready-to-run"`
+			Trimmed       bool              `yaml:"trimmed"`
+			SingleFile    bool              `yaml:"single-file"`
+		} `yaml:"publish"`
+		Test struct {
+			Enabled bool   `yaml:"enabled"`
+			Filter  string `yaml:"filter"`
+		} `yaml:"test"`
+		Processes  map[string]string `yaml:"processes"`
+		Migrations struct {
+			Enabled bool   `yaml:"enabled"`
+			Process string `yaml:"process"`
+			Project string `yaml:"project"`
+			Context string `yaml:"context"`
+		} `yaml:"migrations"`
+		Start struct {
+			Assembly   string `yaml:"assembly"`
+			Args       string `yaml:"args"`
+			WorkingDir string `yaml:"working-dir"`
+		} `yaml:"start"`
 	} `yaml:"dotnet-core"`
 }
 
+func (b buildpackYaml) targetFramework() string {
+	if b.DotnetCore.Publish.Framework != "" {
+		return b.DotnetCore.Publish.Framework
+	}
+	return b.DotnetCore.Framework
+}
+
 func (s *Supplier) parseBuildpackYamlVersion() (string, error) {
 	content, err := s.parseBuildpackYamlFile()
 	if err != nil {
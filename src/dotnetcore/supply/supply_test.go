@@ -2,11 +2,13 @@ package supply_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/platform"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/supply"
 
@@ -200,6 +202,314 @@ var _ = Describe("Supply", func() {
 		})
 	})
 
+	Describe("CheckPlatform", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "CF_STACK")
+		})
+
+		It("succeeds when there is an SDK and a runtime for the stack and architecture", func() {
+			mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"8.0.204"})
+			mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"8.0.4"})
+
+			Expect(supplier.CheckPlatform()).To(Succeed())
+		})
+
+		It("fails when there is no runtime for the stack and architecture", func() {
+			mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"8.0.204"})
+			mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return(nil)
+
+			Expect(supplier.CheckPlatform()).To(MatchError(fmt.Sprintf("this buildpack has no dotnet-runtime for stack cflinuxfs4 on %s", platform.Arch())))
+		})
+
+		It("fails for an unsupported stack", func() {
+			Expect(os.Setenv("CF_STACK", "windows")).To(Succeed())
+
+			Expect(supplier.CheckPlatform()).To(MatchError("unsupported stack: windows"))
+		})
+	})
+
+	Describe("ReadPublishSettings", func() {
+		writeBuildpackYml := func(publish string) {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  publish:\n"+publish), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+		})
+
+		It("reads the publish section into the config", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "Properties", "PublishProfiles"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "Properties", "PublishProfiles", "FolderProfile.pubxml"), []byte("<Project />"), 0644)).To(Succeed())
+			writeBuildpackYml(fmt.Sprintf(`    configuration: Release
+    runtime: linux-%s
+    properties:
+      InvariantGlobalization: true
+    profile: FolderProfile
+    verbosity: minimal
+`, platform.Arch()))
+
+			Expect(supplier.ReadPublishSettings()).To(Succeed())
+			Expect(supplier.Config.Publish).To(Equal(config.Publish{
+				Configuration:     "Release",
+				RuntimeIdentifier: "linux-" + platform.Arch(),
+				Properties:        map[string]string{"InvariantGlobalization": "true"},
+				Profile:           "FolderProfile",
+				Verbosity:         "minimal",
+			}))
+		})
+
+		DescribeTable("rejects invalid settings",
+			func(publish, message string) {
+				writeBuildpackYml(publish)
+				Expect(supplier.ReadPublishSettings()).To(MatchError(message))
+			},
+			Entry("a configuration with spaces", "    configuration: My Config\n", "invalid configuration 'My Config'"),
+			Entry("a Windows runtime", "    runtime: win-x64\n", fmt.Sprintf("runtime identifier 'win-x64' does not run on this stack, use a linux runtime identifier for %[1]s such as linux-%[1]s", platform.Arch())),
+			Entry("an invalid property name", "    properties:\n      'Bad Name': x\n", "invalid MSBuild property name 'Bad Name'"),
+			Entry("a property with its own setting", "    properties:\n      Configuration: Release\n", "set the Configuration property with its own setting in the publish section instead of properties"),
+			Entry("a publish option as a property", "    properties:\n      PublishTrimmed: true\n", "set the PublishTrimmed property with its own setting in the publish section instead of properties"),
+			Entry("a missing profile", "    profile: Missing\n", "publish profile Missing not found at Properties/PublishProfiles/Missing.pubxml"),
+			Entry("an unknown verbosity", "    verbosity: loud\n", "invalid verbosity 'loud', must be one of quiet, minimal, normal, detailed or diagnostic"),
+		)
+
+		It("reads the ready-to-run, trimmed and single-file options", func() {
+			writeBuildpackYml("    ready-to-run: true\n    trimmed: true\n    single-file: true\n")
+
+			Expect(supplier.ReadPublishSettings()).To(Succeed())
+			Expect(supplier.Config.Publish.OptionProperties()).To(Equal(map[string]string{
+				"PublishReadyToRun": "true",
+				"PublishTrimmed":    "true",
+				"PublishSingleFile": "true",
+			}))
+			Expect(buffer.String()).NotTo(ContainSubstring("Trimming is enabled"))
+		})
+
+		It("warns when trimming an app that uses reflection-heavy packages", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web">
+  <ItemGroup>
+    <PackageReference Include="Newtonsoft.Json" Version="13.0.3" />
+    <PackageReference Include="Microsoft.EntityFrameworkCore.SqlServer" Version="8.0.0" />
+    <PackageReference Include="Serilog" Version="3.1.1" />
+  </ItemGroup>
+</Project>`), 0644)).To(Succeed())
+			writeBuildpackYml("    trimmed: true\n")
+
+			Expect(supplier.ReadPublishSettings()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Trimming is enabled, but the app references packages that rely on reflection and may break when trimmed: Microsoft.EntityFrameworkCore.SqlServer, Newtonsoft.Json."))
+		})
+
+		It("uses publish.framework as the target framework", func() {
+			writeBuildpackYml("    framework: net8.0\n")
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><TargetFrameworks>net8.0;net10.0</TargetFrameworks></PropertyGroup></Project>`), 0644)).To(Succeed())
+
+			Expect(supplier.ReadPublishSettings()).To(Succeed())
+			Expect(supplier.SelectTargetFramework()).To(Succeed())
+			Expect(supplier.Config.TargetFramework).To(Equal("net8.0"))
+		})
+	})
+
+	Describe("SelectTargetFramework", func() {
+		BeforeEach(func() {
+			csprojXml := `<Project Sdk="Microsoft.NET.Sdk.Web">
+				<PropertyGroup>
+					<TargetFrameworks>net8.0;net10.0</TargetFrameworks>
+				</PropertyGroup>
+			</Project>`
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(csprojXml), 0644)).To(Succeed())
+		})
+
+		Context("with a framework in buildpack.yml", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  framework: net8.0"), 0644)).To(Succeed())
+			})
+
+			It("uses the requested framework", func() {
+				Expect(supplier.SelectTargetFramework()).To(Succeed())
+				Expect(supplier.Config.TargetFramework).To(Equal("net8.0"))
+				Expect(buffer.String()).To(ContainSubstring("Using target framework net8.0"))
+			})
+		})
+
+		Context("without a framework in buildpack.yml", func() {
+			It("uses the highest framework supported by the buildpack", func() {
+				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"8.0.4", "10.0.1"})
+
+				Expect(supplier.SelectTargetFramework()).To(Succeed())
+				Expect(supplier.Config.TargetFramework).To(Equal("net10.0"))
+			})
+		})
+
+		Context("the app is published", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "test_app.runtimeconfig.json"), []byte("any text"), 0644)).To(Succeed())
+			})
+
+			It("does not select a framework", func() {
+				Expect(supplier.SelectTargetFramework()).To(Succeed())
+				Expect(supplier.Config.TargetFramework).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("ReadTestSettings", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+		})
+
+		It("does not run tests by default", func() {
+			Expect(supplier.ReadTestSettings()).To(Succeed())
+			Expect(supplier.Config.Test).To(Equal(config.Test{}))
+		})
+
+		It("reads the test section into the config", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  test:\n    enabled: true\n    filter: Category!=Integration\n"), 0644)).To(Succeed())
+			Expect(supplier.ReadTestSettings()).To(Succeed())
+			Expect(supplier.Config.Test).To(Equal(config.Test{Enabled: true, Filter: "Category!=Integration"}))
+			Expect(buffer.String()).To(ContainSubstring("Running tests before publishing the app"))
+		})
+	})
+
+	Describe("ReadStartSettings", func() {
+		It("reads the start section into the config", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  start:\n    assembly: Fred.Api.dll\n    args: --urls http://0.0.0.0:${PORT}\n    working-dir: content/\n"), 0644)).To(Succeed())
+			Expect(supplier.ReadStartSettings()).To(Succeed())
+			Expect(supplier.Config.Start).To(Equal(config.Start{Assembly: "Fred.Api", Args: "--urls http://0.0.0.0:${PORT}", WorkingDir: "content"}))
+			Expect(buffer.String()).To(ContainSubstring("Starting the app with assembly Fred.Api"))
+		})
+
+		DescribeTable("rejects invalid start settings",
+			func(start, message string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  start:\n"+start), 0644)).To(Succeed())
+				Expect(supplier.ReadStartSettings()).To(MatchError(message))
+			},
+			Entry("an assembly path", "    assembly: bin/Fred.Api.dll\n", "invalid start assembly 'bin/Fred.Api.dll', set the name of the assembly without a directory, such as Fred.Api"),
+			Entry("multi-line args", "    args: |\n      --urls\n      http://0.0.0.0:8080\n", "start args must be on a single line"),
+			Entry("a working directory outside the app", "    working-dir: ../etc\n", "start working-dir ../etc must be a directory in the published app"),
+		)
+	})
+
+	Describe("ReadProcesses", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Worker"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Worker", "Worker.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Worker"></Project>`), 0644)).To(Succeed())
+		})
+
+		It("reads the processes section into the config", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  processes:\n    worker: src/Worker/Worker.csproj\n"), 0644)).To(Succeed())
+			Expect(supplier.ReadProcesses()).To(Succeed())
+			Expect(supplier.Config.Processes).To(Equal(map[string]string{"worker": "src/Worker/Worker.csproj"}))
+			Expect(buffer.String()).To(ContainSubstring("Adding process worker for src/Worker/Worker.csproj"))
+		})
+
+		DescribeTable("rejects invalid processes",
+			func(processes, message string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  processes:\n"+processes), 0644)).To(Succeed())
+				Expect(supplier.ReadProcesses()).To(MatchError(message))
+			},
+			Entry("the web process", "    web: src/Worker/Worker.csproj\n", "the web process runs the app's main project and cannot run another project"),
+			Entry("an invalid name", "    'my worker': src/Worker/Worker.csproj\n", "invalid process type 'my worker'"),
+			Entry("a command", "    worker: dotnet run\n", "process worker must name a project file in the app, such as src/Worker/Worker.csproj"),
+			Entry("a missing project", "    worker: src/Missing/Missing.csproj\n", "project src/Missing/Missing.csproj of process worker not found"),
+		)
+	})
+
+	Describe("ReadMigrationsSettings", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Data"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web">
+  <ItemGroup><PackageReference Include="Microsoft.EntityFrameworkCore.Design" Version="8.0.4" /></ItemGroup>
+</Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Data", "Data.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk"></Project>`), 0644)).To(Succeed())
+		})
+
+		It("does not build a bundle by default", func() {
+			Expect(supplier.ReadMigrationsSettings()).To(Succeed())
+			Expect(supplier.Config.Migrations.Enabled).To(BeFalse())
+		})
+
+		It("reads the migrations section into the config", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  migrations:\n    enabled: true\n    project: src/Data/Data.csproj\n    context: AppDbContext\n"), 0644)).To(Succeed())
+			Expect(supplier.ReadMigrationsSettings()).To(Succeed())
+			Expect(supplier.Config.Migrations).To(Equal(config.Migrations{Enabled: true, Process: "migrate", Project: "src/Data/Data.csproj", Context: "AppDbContext"}))
+			Expect(buffer.String()).To(ContainSubstring("Building an Entity Framework migrations bundle as process migrate"))
+		})
+
+		It("needs a reference to Microsoft.EntityFrameworkCore.Design", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  migrations:\n    enabled: true\n"), 0644)).To(Succeed())
+			Expect(supplier.ReadMigrationsSettings()).To(MatchError("the migrations bundle needs a reference to Microsoft.EntityFrameworkCore.Design in the main project"))
+		})
+
+		It("rejects the web process", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  migrations:\n    enabled: true\n    process: web\n"), 0644)).To(Succeed())
+			Expect(supplier.ReadMigrationsSettings()).To(MatchError("invalid process type 'web' for the migrations bundle"))
+		})
+
+		DescribeTable("rejects projects outside the app",
+			func(project string) {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  migrations:\n    enabled: true\n    project: "+project+"\n"), 0644)).To(Succeed())
+				Expect(supplier.ReadMigrationsSettings()).To(MatchError(fmt.Sprintf("migrations project %s must be a project file in the app, such as src/Data/Data.csproj", filepath.Clean(project))))
+			},
+			Entry("an absolute path", filepath.Join(os.TempDir(), "Data", "Data.csproj")),
+			Entry("a path above the app", "../Data/Data.csproj"),
+			Entry("a path that leaves the app", "src/../../Data/Data.csproj"),
+		)
+	})
+
+	Describe("SelectPublishMode", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+		})
+
+		It("publishes self-contained by default", func() {
+			Expect(supplier.SelectPublishMode()).To(Succeed())
+			Expect(supplier.Config.FrameworkDependent).To(BeFalse())
+		})
+
+		Context("framework-dependent is set in buildpack.yml", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  framework-dependent: true"), 0644)).To(Succeed())
+			})
+
+			It("publishes framework-dependent", func() {
+				Expect(supplier.SelectPublishMode()).To(Succeed())
+				Expect(supplier.Config.FrameworkDependent).To(BeTrue())
+				Expect(buffer.String()).To(ContainSubstring("Publishing app as framework-dependent"))
+			})
+
+			Context("and PUBLISH_FRAMEWORK_DEPENDENT is false", func() {
+				BeforeEach(func() {
+					Expect(os.Setenv("PUBLISH_FRAMEWORK_DEPENDENT", "false")).To(Succeed())
+					DeferCleanup(os.Unsetenv, "PUBLISH_FRAMEWORK_DEPENDENT")
+				})
+
+				It("publishes self-contained", func() {
+					Expect(supplier.SelectPublishMode()).To(Succeed())
+					Expect(supplier.Config.FrameworkDependent).To(BeFalse())
+				})
+			})
+		})
+
+		It("rejects framework-dependent single-file apps", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  framework-dependent: true"), 0644)).To(Succeed())
+			supplier.Config.Publish.SingleFile = true
+			Expect(supplier.SelectPublishMode()).To(MatchError("framework-dependent single-file apps are not supported, because single-file publishing bundles the *.runtimeconfig.json the buildpack reads the frameworks to install from, turn off single-file or framework-dependent publishing"))
+		})
+
+		Context("PUBLISH_FRAMEWORK_DEPENDENT is not a boolean", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("PUBLISH_FRAMEWORK_DEPENDENT", "sometimes")).To(Succeed())
+				DeferCleanup(os.Unsetenv, "PUBLISH_FRAMEWORK_DEPENDENT")
+			})
+
+			It("returns an error", func() {
+				Expect(supplier.SelectPublishMode()).To(MatchError("invalid value 'sometimes' for PUBLISH_FRAMEWORK_DEPENDENT"))
+			})
+		})
+	})
+
 	Describe("LoadLegacySSLProvider", func() {
 		Context("BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER is set", func() {
 			Context("set to true", func() {
@@ -393,7 +703,53 @@ var _ = Describe("Supply", func() {
 					})
 
 					It("returns an error", func() {
-						Expect(supplier.InstallDotnetSdk()).To(MatchError("could not find sdk in same feature line as '1.2.3'"))
+						Expect(supplier.InstallDotnetSdk()).To(MatchError("could not find sdk matching '1.2.3' with rollForward policy 'latestPatch' (allowPrerelease: true) in [1.1.1 1.3.7]"))
+					})
+				})
+			})
+
+			Context("with sdk/rollForward", func() {
+				var versions = []string{"6.0.100", "6.0.203", "6.0.208", "6.1.104", "6.2.101", "7.0.101", "7.1.100-preview.1"}
+
+				DescribeTable("installs the version chosen by the policy",
+					func(globalJSON, expected string) {
+						Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(globalJSON), 0644)).To(Succeed())
+						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return(versions)
+						mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: expected}, filepath.Join(depsDir, depsIdx, "dotnet-sdk"))
+
+						Expect(supplier.InstallDotnetSdk()).To(Succeed())
+					},
+					Entry("patch uses the exact version", `{"sdk": {"version": "6.0.203", "rollForward": "patch"}}`, "6.0.203"),
+					Entry("latestPatch uses the latest patch in the feature band", `{"sdk": {"version": "6.0.203", "rollForward": "latestPatch"}}`, "6.0.208"),
+					Entry("feature rolls to the next feature band", `{"sdk": {"version": "6.0.150", "rollForward": "feature"}}`, "6.0.208"),
+					Entry("minor rolls to the next minor", `{"sdk": {"version": "6.0.300", "rollForward": "minor"}}`, "6.1.104"),
+					Entry("major rolls to the next major", `{"sdk": {"version": "6.3.100", "rollForward": "major"}}`, "7.0.101"),
+					Entry("latestFeature uses the latest feature band", `{"sdk": {"version": "6.0.100", "rollForward": "latestFeature"}}`, "6.0.208"),
+					Entry("latestMinor uses the latest minor", `{"sdk": {"version": "6.0.100", "rollForward": "latestMinor"}}`, "6.2.101"),
+					Entry("latestMajor uses the latest prerelease by default", `{"sdk": {"rollForward": "latestMajor"}}`, "7.1.100-preview.1"),
+					Entry("latestMajor skips prereleases when allowPrerelease is false", `{"sdk": {"rollForward": "latestMajor", "allowPrerelease": false}}`, "7.0.101"),
+					Entry("disable uses the exact version", `{"sdk": {"version": "6.0.100", "rollForward": "disable"}}`, "6.0.100"),
+				)
+
+				Context("that is disable and the exact version is missing", func() {
+					BeforeEach(func() {
+						Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{"sdk": {"version": "6.0.201", "rollForward": "disable"}}`), 0644)).To(Succeed())
+						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return(versions)
+					})
+
+					It("returns an error", func() {
+						Expect(supplier.InstallDotnetSdk()).To(MatchError(ContainSubstring("could not find sdk matching '6.0.201' with rollForward policy 'disable'")))
+					})
+				})
+
+				Context("that is not a valid policy", func() {
+					BeforeEach(func() {
+						Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{"sdk": {"version": "6.0.100", "rollForward": "sideways"}}`), 0644)).To(Succeed())
+						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return(versions)
+					})
+
+					It("returns an error", func() {
+						Expect(supplier.InstallDotnetSdk()).To(MatchError("invalid rollForward policy 'sideways' in global.json"))
 					})
 				})
 			})
@@ -472,4 +828,77 @@ var _ = Describe("Supply", func() {
 			})
 		})
 	})
+
+	Describe("SelectGlobalizationMode", func() {
+		var profileD string
+
+		BeforeEach(func() {
+			profileD = filepath.Join(depsDir, depsIdx, "profile.d", "dotnet-globalization.sh")
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`), 0644)).To(Succeed())
+			Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "CF_STACK")
+		})
+
+		It("uses the ICU of the stack", func() {
+			mockCommand.EXPECT().Output(buildDir, "sh", "-c", gomock.Any()).Return("\tlibicuuc.so.70 (libc6,x86-64) => /lib/x86_64-linux-gnu/libicuuc.so.70\n", nil)
+			Expect(supplier.SelectGlobalizationMode()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Using ICU 70 of stack cflinuxfs4"))
+			Expect(profileD).NotTo(BeAnExistingFile())
+		})
+
+		It("turns on globalization-invariant mode when the stack has no ICU", func() {
+			mockCommand.EXPECT().Output(buildDir, "sh", "-c", gomock.Any()).Return("\tlibz.so.1 (libc6,x86-64) => /lib/x86_64-linux-gnu/libz.so.1\n", nil)
+			Expect(supplier.SelectGlobalizationMode()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Stack cflinuxfs4 has no ICU the .NET runtime can load"))
+
+			contents, err := os.ReadFile(profileD)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(`export DOTNET_SYSTEM_GLOBALIZATION_INVARIANT="${DOTNET_SYSTEM_GLOBALIZATION_INVARIANT:-true}"` + "\n"))
+		})
+
+		It("does not look for ICU when the project sets InvariantGlobalization", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><InvariantGlobalization>true</InvariantGlobalization></PropertyGroup></Project>`), 0644)).To(Succeed())
+			Expect(supplier.SelectGlobalizationMode()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("App runs in globalization-invariant mode and does not need ICU"))
+		})
+
+		It("does not look for ICU when the runtimeconfig.json sets System.Globalization.Invariant", func() {
+			Expect(os.Remove(filepath.Join(buildDir, "test_app.csproj"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.runtimeconfig.json"), []byte(`{"runtimeOptions": {"configProperties": {"System.Globalization.Invariant": true}}}`), 0644)).To(Succeed())
+			Expect(supplier.SelectGlobalizationMode()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("App runs in globalization-invariant mode and does not need ICU"))
+		})
+	})
+
+	Describe("CheckNativeAot", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><PublishAot>true</PublishAot></PropertyGroup></Project>`), 0644)).To(Succeed())
+			Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "CF_STACK")
+		})
+
+		It("publishes with Native AOT when the stack has clang and the zlib headers", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "clang", "--version")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "sh", "-c", gomock.Any())
+			Expect(supplier.CheckNativeAot()).To(Succeed())
+			Expect(supplier.Config.NativeAot).To(BeTrue())
+			Expect(buffer.String()).To(ContainSubstring("Publishing app with Native AOT"))
+		})
+
+		It("fails when the stack has no clang", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "clang", "--version").Return(errors.New("not found"))
+			Expect(supplier.CheckNativeAot()).To(MatchError("Native AOT needs clang, which is not installed on stack cflinuxfs4"))
+		})
+
+		It("fails when the stack has no zlib headers", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "clang", "--version")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "sh", "-c", gomock.Any()).Return(errors.New("zlib.h: No such file or directory"))
+			Expect(supplier.CheckNativeAot()).To(MatchError("Native AOT needs the zlib headers (zlib.h), which are not installed on stack cflinuxfs4"))
+		})
+
+		It("rejects framework-dependent Native AOT apps", func() {
+			supplier.Config.FrameworkDependent = true
+			Expect(supplier.CheckNativeAot()).To(MatchError("Native AOT apps must be self-contained, turn off PublishAot or framework-dependent publishing"))
+		})
+	})
 })